@@ -0,0 +1,82 @@
+// Command fastdu summarizes disk usage of file trees, like du(1), using
+// fastwalk.
+//
+// Usage:
+//
+//	fastdu [-max-depth n] [-top n] [-json] [dir ...]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/shanebarnes/bits/fastwalk/du"
+)
+
+type result struct {
+	Root  string     `json:"root"`
+	Total du.Usage   `json:"total"`
+	Dirs  []du.Usage `json:"dirs,omitempty"`
+	Top   []du.Usage `json:"top,omitempty"`
+}
+
+func main() {
+	maxDepth := flag.Int("max-depth", 0, "report directories at most this deep below each root (-1 for all)")
+	top := flag.Int("top", 10, "report the n largest subtrees (0 to disable)")
+	jsonOut := flag.Bool("json", false, "emit JSON instead of tables")
+	flag.Parse()
+
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	status := 0
+	for _, root := range roots {
+		r, err := du.Scan(root)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		res := result{Root: r.Root, Total: r.Total, Dirs: r.Dirs(*maxDepth)}
+		if *top > 0 {
+			res.Top = r.Top(*top)
+		}
+		if *jsonOut {
+			if err := enc.Encode(res); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			printTables(os.Stdout, &res)
+		}
+	}
+	os.Exit(status)
+}
+
+func printTables(w io.Writer, res *result) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	printTable(tw, res.Dirs)
+	if len(res.Top) > 0 {
+		fmt.Fprintf(tw, "\nLargest subtrees of %s:\n", res.Root)
+		printTable(tw, res.Top)
+	}
+	tw.Flush()
+}
+
+func printTable(w io.Writer, us []du.Usage) {
+	fmt.Fprintln(w, "ALLOCATED\tAPPARENT\tFILES\tDIRS\t\tPATH")
+	for _, u := range us {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t\t%s\n",
+			humanize.Bytes(uint64(u.AllocatedBytes)),
+			humanize.Bytes(uint64(u.ApparentBytes)),
+			u.Files, u.Dirs, u.Path)
+	}
+}
@@ -0,0 +1,70 @@
+// Command fastmanifest writes a manifest of a file tree to standard
+// output, or verifies a tree against a manifest, using fastwalk.
+//
+// Usage:
+//
+//	fastmanifest [-format jsonl|csv|mtree] [-checksum] dir
+//	fastmanifest [-format jsonl|csv|mtree] -verify manifest dir
+//
+// Verification prints one line per mismatch and exits with status 1 if
+// any are found.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/shanebarnes/bits/fastwalk/manifest"
+)
+
+func main() {
+	format := flag.String("format", "jsonl", "manifest format: jsonl, csv or mtree")
+	checksum := flag.Bool("checksum", false, "include SHA-256 digests of regular files")
+	verify := flag.String("verify", "", "verify the tree against this manifest instead of writing one")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	root := flag.Arg(0)
+	f, err := manifest.ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *verify == "" {
+		w, err := manifest.NewWriter(os.Stdout, f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := manifest.Export(root, w, &manifest.Options{Checksum: *checksum}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	file, err := os.Open(*verify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	r, err := manifest.NewReader(file, f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mismatches := 0
+	err = manifest.Verify(root, r, func(m manifest.Mismatch) error {
+		mismatches++
+		fmt.Println(m)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
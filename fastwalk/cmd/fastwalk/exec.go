@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+)
+
+// maxExecBytes bounds the total length of the paths passed to one run
+// of the -exec command, keeping well below common ARG_MAX limits.
+const maxExecBytes = 128 << 10
+
+// batcher runs a command on batches of paths, like find's
+// "-exec command {} +". Batches are run one at a time, in the order the
+// paths were added.
+type batcher struct {
+	argv    []string
+	maxArgs int
+	pathc   chan string
+	donec   chan struct{}
+	failed  bool
+}
+
+// newBatcher returns a batcher running argv with at most maxArgs paths
+// at a time. The paths replace an argument "{}" if there is one, and
+// are appended to argv otherwise.
+func newBatcher(argv []string, maxArgs int) *batcher {
+	if maxArgs < 1 {
+		maxArgs = 1
+	}
+	b := &batcher{
+		argv:    argv,
+		maxArgs: maxArgs,
+		pathc:   make(chan string, maxArgs),
+		donec:   make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// add queues path. It is safe for concurrent use.
+func (b *batcher) add(path string) {
+	b.pathc <- path
+}
+
+// wait runs the last batch and reports whether every run succeeded.
+func (b *batcher) wait() bool {
+	close(b.pathc)
+	<-b.donec
+	return !b.failed
+}
+
+func (b *batcher) loop() {
+	defer close(b.donec)
+	var batch []string
+	size := 0
+	for path := range b.pathc {
+		batch = append(batch, path)
+		size += len(path) + 1
+		if len(batch) >= b.maxArgs || size >= maxExecBytes {
+			b.run(batch)
+			batch, size = batch[:0], 0
+		}
+	}
+	if len(batch) > 0 {
+		b.run(batch)
+	}
+}
+
+func (b *batcher) run(paths []string) {
+	var args []string
+	replaced := false
+	for _, arg := range b.argv[1:] {
+		if arg == "{}" && !replaced {
+			args = append(args, paths...)
+			replaced = true
+			continue
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, paths...)
+	}
+	cmd := exec.Command(b.argv[0], args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s: %v", b.argv[0], err)
+		b.failed = true
+	}
+}
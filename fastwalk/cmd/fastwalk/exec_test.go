@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatcher(t *testing.T) {
+	tests := []struct {
+		argv    string
+		maxArgs int
+		paths   int
+		want    []string // the arguments of each run
+	}{
+		{"", 3, 7, []string{"p0 p1 p2", "p3 p4 p5", "p6"}},
+		{"", 10, 2, []string{"p0 p1"}},
+		{"", 0, 2, []string{"p0", "p1"}},
+		{"", 2, 0, nil},
+		{"first {} last", 2, 3, []string{"first p0 p1 last", "first p2 last"}},
+		{"{} {}", 5, 2, []string{"p0 p1 {}"}},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(t.TempDir(), "out")
+		// Each run appends its arguments to out as a line.
+		argv := append([]string{"sh", "-c", `echo "$@" >> ` + out, "sh"}, strings.Fields(tt.argv)...)
+		b := newBatcher(argv, tt.maxArgs)
+		for i := 0; i < tt.paths; i++ {
+			b.add(fmt.Sprintf("p%d", i))
+		}
+		if !b.wait() {
+			t.Errorf("%q: wait() = false, want true", tt.argv)
+		}
+		data, _ := ioutil.ReadFile(out)
+		var got []string
+		if s := strings.TrimSpace(string(data)); s != "" {
+			got = strings.Split(s, "\n")
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q with at most %d paths: runs %q, want %q", tt.argv, tt.maxArgs, got, tt.want)
+		}
+	}
+}
+
+func TestBatcher_Failure(t *testing.T) {
+	b := newBatcher([]string{"false"}, 1)
+	b.add("p0")
+	if b.wait() {
+		t.Error("wait() = true for a failing command, want false")
+	}
+}
+
+func TestBatcher_MaxBytes(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	b := newBatcher([]string{"sh", "-c", `echo "$#" >> ` + out, "sh"}, 1000)
+	path := strings.Repeat("x", 1023)
+	n := 2 * maxExecBytes / (len(path) + 1)
+	for i := 0; i < n; i++ {
+		b.add(path)
+	}
+	if !b.wait() {
+		t.Fatal("wait() = false, want true")
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%d\n%d\n", n/2, n/2)
+	if string(data) != want {
+		t.Errorf("runs of %q paths, want %q", data, want)
+	}
+}
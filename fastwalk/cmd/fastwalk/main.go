@@ -0,0 +1,147 @@
+// Command fastwalk lists the entries of file trees that match find(1)
+// style predicates, using fastwalk.
+//
+// Usage:
+//
+//	fastwalk [flags] [dir ...]
+//
+// All given predicates must match for an entry to be selected. Entries
+// are printed in no particular order, one per line, or as JSON Lines
+// with -json. With -exec, the selected paths are instead passed in
+// batches to a command, like "find -exec command {} +":
+//
+//	fastwalk -name '*.go' -exec 'gofmt -l'
+//	fastwalk -type f -size +1M -mtime +30 -print0 | xargs -0 ls -l
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+type record struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fastwalk: ")
+
+	name := flag.String("name", "", "select entries whose base name matches this shell `pattern`")
+	typ := flag.String("type", "", "select entries of these comma-separated `types`: f, d, l, p, s, c or b")
+	size := flag.String("size", "", "select entries of `[+-]n[cwbkMG]` size, in 512-byte blocks if no unit is given")
+	mtime := flag.String("mtime", "", "select entries modified `[+-]n` days ago")
+	newer := flag.String("newer", "", "select entries modified more recently than `file`")
+	print0 := flag.Bool("print0", false, "end each path with a NUL byte instead of a newline")
+	jsonOut := flag.Bool("json", false, "print a JSON object for each entry")
+	execCmd := flag.String("exec", "", "run `command` with the selected paths as arguments, replacing {} if present")
+	execBatch := flag.Int("exec-batch", 1000, "pass at most `n` paths to each run of the -exec command")
+	workers := flag.Int("workers", 0, "number of directory reading goroutines (0 for the default)")
+	flag.Parse()
+
+	m, err := newMatcher(predicateFlags{
+		name:  *name,
+		typ:   *typ,
+		size:  *size,
+		mtime: *mtime,
+		newer: *newer,
+	}, time.Now(), *jsonOut)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var batch *batcher
+	if *execCmd != "" {
+		argv := strings.Fields(*execCmd)
+		if len(argv) == 0 {
+			log.Fatal("-exec: empty command")
+		}
+		batch = newBatcher(argv, *execBatch)
+	}
+
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	pr := &printer{w: out, print0: *print0}
+	if *jsonOut {
+		pr.enc = json.NewEncoder(out)
+	}
+	status := 0
+	var mu sync.Mutex
+	for _, root := range roots {
+		opts := &fastwalk.Options{NumWorkers: *workers}
+		err := fastwalk.WalkEntries(root, opts, func(e fastwalk.Entry) error {
+			fi, ok, err := m.match(e.Path, e.Type)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					log.Print(err)
+					mu.Lock()
+					status = 1
+					mu.Unlock()
+				}
+				return nil
+			}
+			if !ok {
+				return nil
+			}
+			if batch != nil {
+				batch.add(e.Path)
+				return nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			return pr.print(e, fi)
+		})
+		if err != nil {
+			log.Print(err)
+			status = 1
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if batch != nil && !batch.wait() {
+		status = 1
+	}
+	os.Exit(status)
+}
+
+type printer struct {
+	w      io.Writer
+	enc    *json.Encoder
+	print0 bool
+}
+
+func (p *printer) print(e fastwalk.Entry, fi os.FileInfo) error {
+	if p.enc != nil {
+		return p.enc.Encode(record{
+			Path:    e.Path,
+			Type:    string(typeLetter(e.Type)),
+			Size:    fi.Size(),
+			Mode:    fi.Mode().String(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	sep := "\n"
+	if p.print0 {
+		sep = "\x00"
+	}
+	_, err := io.WriteString(p.w, e.Path+sep)
+	return err
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A predicate reports whether an entry matches. fi is nil unless the
+// predicate's needsStat is true.
+type predicate struct {
+	match     func(path string, typ os.FileMode, fi os.FileInfo) bool
+	needsStat bool
+}
+
+// predicateFlags are the predicate flags given on the command line. An
+// empty flag selects every entry.
+type predicateFlags struct {
+	name, typ, size, mtime, newer string
+}
+
+// matcher selects the entries matched by all of its predicates. The
+// predicates that need no stat run first, so only the entries that
+// pass them are stat'ed.
+type matcher struct {
+	cheap, stat []predicate
+	statAll     bool // stat every selected entry, for output
+	lstat       func(path string) (os.FileInfo, error)
+}
+
+// newMatcher returns a matcher for the predicates of f, taking now as
+// the current time. If statAll is true, selected entries are stat'ed
+// even if no predicate needs it.
+func newMatcher(f predicateFlags, now time.Time, statAll bool) (*matcher, error) {
+	m := &matcher{statAll: statAll, lstat: os.Lstat}
+	add := func(arg string, parse func(string) (predicate, error)) error {
+		if arg == "" {
+			return nil
+		}
+		p, err := parse(arg)
+		if err != nil {
+			return err
+		}
+		if p.needsStat {
+			m.stat = append(m.stat, p)
+		} else {
+			m.cheap = append(m.cheap, p)
+		}
+		return nil
+	}
+	for _, err := range []error{
+		add(f.name, nameMatcher),
+		add(f.typ, typeMatcher),
+		add(f.size, sizeMatcher),
+		add(f.mtime, func(arg string) (predicate, error) { return mtimeMatcher(arg, now) }),
+		add(f.newer, newerMatcher),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// match reports whether the entry at path of type typ is selected, and
+// returns its FileInfo if it was stat'ed. The error is that of the stat.
+func (m *matcher) match(path string, typ os.FileMode) (os.FileInfo, bool, error) {
+	for _, p := range m.cheap {
+		if !p.match(path, typ, nil) {
+			return nil, false, nil
+		}
+	}
+	if len(m.stat) == 0 && !m.statAll {
+		return nil, true, nil
+	}
+	fi, err := m.lstat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, p := range m.stat {
+		if !p.match(path, typ, fi) {
+			return nil, false, nil
+		}
+	}
+	return fi, true, nil
+}
+
+func nameMatcher(pattern string) (predicate, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return predicate{}, fmt.Errorf("-name %q: %v", pattern, err)
+	}
+	return predicate{match: func(path string, typ os.FileMode, fi os.FileInfo) bool {
+		ok, _ := filepath.Match(pattern, filepath.Base(path))
+		return ok
+	}}, nil
+}
+
+// typeLetter returns the find(1) -type letter of typ.
+func typeLetter(typ os.FileMode) byte {
+	switch {
+	case typ.IsRegular():
+		return 'f'
+	case typ&os.ModeDir != 0:
+		return 'd'
+	case typ&os.ModeSymlink != 0:
+		return 'l'
+	case typ&os.ModeNamedPipe != 0:
+		return 'p'
+	case typ&os.ModeSocket != 0:
+		return 's'
+	case typ&os.ModeCharDevice != 0:
+		return 'c'
+	case typ&os.ModeDevice != 0:
+		return 'b'
+	}
+	return '?'
+}
+
+// typeMatcher accepts a comma-separated list of -type letters, as GNU
+// find does.
+func typeMatcher(list string) (predicate, error) {
+	want := map[byte]bool{}
+	for _, s := range strings.Split(list, ",") {
+		if len(s) != 1 || !strings.Contains("fdlpscb", s) {
+			return predicate{}, fmt.Errorf("-type %q: unknown type %q", list, s)
+		}
+		want[s[0]] = true
+	}
+	return predicate{match: func(path string, typ os.FileMode, fi os.FileInfo) bool {
+		return want[typeLetter(typ)]
+	}}, nil
+}
+
+// parseCount parses find's numeric arguments: n, +n (more than n) or
+// -n (less than n). It returns the comparison sign and the rest of s.
+func parseCount(s string) (sign int, rest string) {
+	switch {
+	case strings.HasPrefix(s, "+"):
+		return 1, s[1:]
+	case strings.HasPrefix(s, "-"):
+		return -1, s[1:]
+	}
+	return 0, s
+}
+
+func compare(sign int, got, n int64) bool {
+	switch sign {
+	case 1:
+		return got > n
+	case -1:
+		return got < n
+	}
+	return got == n
+}
+
+var sizeUnits = map[byte]int64{
+	'c': 1,
+	'w': 2,
+	'b': 512,
+	'k': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+}
+
+// sizeMatcher implements -size [+-]n[cwbkMG]. As in find, sizes are
+// rounded up to whole units, which are 512-byte blocks by default.
+func sizeMatcher(arg string) (predicate, error) {
+	sign, s := parseCount(arg)
+	unit := int64(512)
+	if s != "" {
+		if u, ok := sizeUnits[s[len(s)-1]]; ok {
+			unit = u
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return predicate{}, fmt.Errorf("-size %q: invalid size", arg)
+	}
+	return predicate{needsStat: true, match: func(path string, typ os.FileMode, fi os.FileInfo) bool {
+		return compare(sign, (fi.Size()+unit-1)/unit, n)
+	}}, nil
+}
+
+// mtimeMatcher implements -mtime [+-]n, comparing the age of the entry
+// in whole days, rounded down, as find does.
+func mtimeMatcher(arg string, now time.Time) (predicate, error) {
+	sign, s := parseCount(arg)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return predicate{}, fmt.Errorf("-mtime %q: invalid number of days", arg)
+	}
+	return predicate{needsStat: true, match: func(path string, typ os.FileMode, fi os.FileInfo) bool {
+		days := int64(now.Sub(fi.ModTime()) / (24 * time.Hour))
+		return compare(sign, days, n)
+	}}, nil
+}
+
+func newerMatcher(file string) (predicate, error) {
+	ref, err := os.Stat(file)
+	if err != nil {
+		return predicate{}, fmt.Errorf("-newer: %v", err)
+	}
+	t := ref.ModTime()
+	return predicate{needsStat: true, match: func(path string, typ os.FileMode, fi os.FileInfo) bool {
+		return fi.ModTime().After(t)
+	}}, nil
+}
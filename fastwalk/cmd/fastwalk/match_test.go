@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewMatcher_Errors(t *testing.T) {
+	for _, f := range []predicateFlags{
+		{name: "["},
+		{typ: "x"},
+		{typ: "f,"},
+		{typ: "fd"},
+		{size: "1x"},
+		{size: "+"},
+		{size: "--1"},
+		{mtime: "1d"},
+		{mtime: "+-1"},
+		{newer: "/nonexistent/file"},
+	} {
+		if _, err := newMatcher(f, time.Now(), false); err == nil {
+			t.Errorf("newMatcher(%+v) succeeded, want an error", f)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	tempdir := t.TempDir()
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(tempdir, name)
+		if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	day := 24 * time.Hour
+	small := write("small.go", 100, time.Hour)
+	big := write("big.txt", 3<<20, 40*day)
+	ref := write("ref", 0, 10*day)
+	dir := filepath.Join(tempdir, "sub.go")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flags predicateFlags
+		path  string
+		typ   os.FileMode
+		want  bool
+	}{
+		{predicateFlags{}, small, 0, true},
+		{predicateFlags{name: "*.go"}, small, 0, true},
+		{predicateFlags{name: "*.go"}, big, 0, false},
+		{predicateFlags{name: "*.go"}, dir, os.ModeDir, true},
+		{predicateFlags{typ: "f"}, dir, os.ModeDir, false},
+		{predicateFlags{typ: "f,d"}, dir, os.ModeDir, true},
+		{predicateFlags{typ: "l"}, small, os.ModeSymlink, true},
+		{predicateFlags{size: "1"}, small, 0, true},
+		{predicateFlags{size: "100c"}, small, 0, true},
+		{predicateFlags{size: "+99c"}, small, 0, true},
+		{predicateFlags{size: "-100c"}, small, 0, false},
+		{predicateFlags{size: "+1M"}, big, 0, true},
+		{predicateFlags{size: "3M"}, big, 0, true},
+		{predicateFlags{size: "-3M"}, big, 0, false},
+		{predicateFlags{size: "+1k"}, small, 0, false},
+		{predicateFlags{mtime: "0"}, small, 0, true},
+		{predicateFlags{mtime: "+30"}, big, 0, true},
+		{predicateFlags{mtime: "+30"}, small, 0, false},
+		{predicateFlags{mtime: "-1"}, small, 0, true},
+		{predicateFlags{mtime: "40"}, big, 0, true},
+		{predicateFlags{newer: ref}, small, 0, true},
+		{predicateFlags{newer: ref}, big, 0, false},
+		{predicateFlags{name: "*.txt", typ: "f", size: "+1M", mtime: "+30"}, big, 0, true},
+		{predicateFlags{name: "*.txt", typ: "f", size: "+1M", mtime: "-30"}, big, 0, false},
+	}
+	for _, tt := range tests {
+		m, err := newMatcher(tt.flags, now, false)
+		if err != nil {
+			t.Fatalf("newMatcher(%+v): %v", tt.flags, err)
+		}
+		_, got, err := m.match(tt.path, tt.typ)
+		if err != nil {
+			t.Fatalf("match(%+v, %s): %v", tt.flags, tt.path, err)
+		}
+		if got != tt.want {
+			t.Errorf("match(%+v, %s) = %v, want %v", tt.flags, filepath.Base(tt.path), got, tt.want)
+		}
+	}
+}
+
+// TestMatcher_StatOnlyCandidates checks that entries rejected by the
+// predicates that need no stat are never stat'ed.
+func TestMatcher_StatOnlyCandidates(t *testing.T) {
+	tests := []struct {
+		flags   predicateFlags
+		statAll bool
+		path    string
+		typ     os.FileMode
+		stats   int
+		want    bool
+	}{
+		{predicateFlags{name: "*.go", size: "+0"}, false, "a.txt", 0, 0, false},
+		{predicateFlags{typ: "d", mtime: "0"}, false, "a.go", 0, 0, false},
+		{predicateFlags{name: "*.go", size: "+0"}, false, "a.go", 0, 1, true},
+		{predicateFlags{name: "*.go"}, false, "a.go", 0, 0, true},
+		{predicateFlags{name: "*.go"}, true, "a.go", 0, 1, true},
+		{predicateFlags{name: "*.go"}, true, "a.txt", 0, 0, false},
+	}
+	for _, tt := range tests {
+		m, err := newMatcher(tt.flags, time.Now(), tt.statAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := 0
+		m.lstat = func(path string) (os.FileInfo, error) {
+			stats++
+			return fakeFileInfo{size: 1, modTime: time.Now()}, nil
+		}
+		_, got, err := m.match(tt.path, tt.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want || stats != tt.stats {
+			t.Errorf("match(%+v, %s) = %v after %d stats, want %v after %d", tt.flags, tt.path, got, stats, tt.want, tt.stats)
+		}
+	}
+}
+
+type fakeFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (fi fakeFileInfo) Name() string       { return "" }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,428 @@
+// Package distwalk spreads a fastwalk walk over several processes.
+//
+// A Coordinator owns the queues of directories to read and serves them
+// over a net.Listener, such as a Unix socket or TCP port. Workers,
+// started with Work in any number of processes, lease directories from
+// the coordinator, read them with fastwalk.WalkQueue and send back the
+// entries found together with the subdirectories to read next.
+//
+// The subdirectories a worker finds are queued for that worker, which
+// reads them depth first, newest first. A worker whose own queue is
+// empty steals the oldest unstarted directory, usually the largest
+// subtree, from the worker with the longest queue, so the walk stays
+// balanced however the tree is shaped. Directories that belong to no
+// worker, such as the root and those whose lease expired, are queued
+// centrally and leased before any are stolen.
+//
+// The coordinator merges the entries from all workers into a single
+// stream passed to its callback. A directory's entries are only passed
+// on once its worker has finished reading it, so a directory whose
+// worker dies or stalls past the lease timeout is read again by another
+// worker without duplicating entries.
+package distwalk
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// DefaultLeaseTimeout is the lease timeout used when
+// Coordinator.LeaseTimeout is zero.
+const DefaultLeaseTimeout = 30 * time.Second
+
+// serviceName is the net/rpc service name of the coordinator.
+const serviceName = "Coordinator"
+
+// Coordinator hands out the directories of a walk to workers and merges
+// their results.
+type Coordinator struct {
+	// LeaseTimeout is how long a worker may hold a directory without
+	// renewing its lease before the directory is given to another
+	// worker. Workers renew leases at a third of this interval. If
+	// zero, DefaultLeaseTimeout is used.
+	LeaseTimeout time.Duration
+
+	fn func(e fastwalk.Entry) error
+
+	mu        sync.Mutex
+	cond      *sync.Cond          // signaled when queues, leases or err change
+	queue     []fastwalk.WorkItem // of no worker
+	sessions  map[*session]bool
+	queued    int // directories in the queues of sessions
+	steals    int
+	leases    map[uint64]*lease
+	nextToken uint64
+	done      bool
+	err       error
+
+	fnMu sync.Mutex // serializes calls to fn
+}
+
+type lease struct {
+	item      fastwalk.WorkItem
+	session   *session
+	deadline  time.Time
+	finishing bool // results are being merged; do not expire
+}
+
+// Serve walks the file tree rooted at root using the workers that
+// connect to ln, calling fn for each entry they find. fn is never
+// called concurrently and has the same meaning as for fastwalk.Walk,
+// including filepath.SkipDir, fastwalk.ErrSkipFiles and
+// fastwalk.ErrTraverseLink.
+//
+// Serve returns once every directory has been read, or fn or a worker
+// reports an error. It closes ln before returning.
+func (c *Coordinator) Serve(ln net.Listener, root string, fn func(e fastwalk.Entry) error) error {
+	defer ln.Close()
+	timeout := c.leaseTimeout()
+	c.fn = fn
+	c.cond = sync.NewCond(&c.mu)
+	c.leases = map[uint64]*lease{}
+	c.sessions = map[*session]bool{}
+	c.queue = []fastwalk.WorkItem{{Dir: root}}
+
+	go c.accept(ln)
+
+	expiry := time.NewTicker(timeout / 4)
+	defer expiry.Stop()
+	donec := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		for !c.done {
+			c.cond.Wait()
+		}
+		c.mu.Unlock()
+		close(donec)
+	}()
+	for {
+		select {
+		case <-donec:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.err
+		case now := <-expiry.C:
+			c.expire(now)
+		}
+	}
+}
+
+func (c *Coordinator) leaseTimeout() time.Duration {
+	if c.LeaseTimeout > 0 {
+		return c.LeaseTimeout
+	}
+	return DefaultLeaseTimeout
+}
+
+func (c *Coordinator) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s := &session{c: c}
+		c.mu.Lock()
+		c.sessions[s] = true
+		c.mu.Unlock()
+		srv := rpc.NewServer()
+		srv.RegisterName(serviceName, s)
+		go func() {
+			srv.ServeConn(conn)
+			// The worker is gone; so are its leases.
+			c.release(s)
+		}()
+	}
+}
+
+// expire requeues the directories whose leases ran out.
+func (c *Coordinator) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, l := range c.leases {
+		if !l.finishing && now.After(l.deadline) {
+			delete(c.leases, token)
+			c.queue = append(c.queue, l.item)
+		}
+	}
+	c.cond.Broadcast()
+}
+
+// release requeues the directories leased or queued to s.
+func (c *Coordinator) release(s *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, l := range c.leases {
+		if l.session == s && !l.finishing {
+			delete(c.leases, token)
+			c.queue = append(c.queue, l.item)
+		}
+	}
+	c.queue = append(c.queue, s.queue...)
+	c.queued -= len(s.queue)
+	s.queue = nil
+	delete(c.sessions, s)
+	c.cond.Broadcast()
+}
+
+// Steals returns the number of directories taken by a worker from the
+// queue of another.
+func (c *Coordinator) Steals() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.steals
+}
+
+// fail ends the walk with err. c.mu must be held.
+func (c *Coordinator) fail(err error) {
+	if !c.done {
+		c.done = true
+		c.err = err
+		c.cond.Broadcast()
+	}
+}
+
+// checkDone ends the walk if nothing is left to do. c.mu must be held.
+func (c *Coordinator) checkDone() {
+	if len(c.queue) == 0 && c.queued == 0 && len(c.leases) == 0 {
+		c.fail(nil)
+	}
+}
+
+// get blocks until a directory can be leased to s. It takes the newest
+// directory of the queue of s, else the newest of the central queue,
+// else steals the oldest of the longest queue of another session.
+func (c *Coordinator) get(s *session) (fastwalk.WorkItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.done && c.queued == 0 && len(c.queue) == 0 {
+		c.cond.Wait()
+	}
+	if c.done {
+		return fastwalk.WorkItem{}, false
+	}
+	var it fastwalk.WorkItem
+	switch {
+	case len(s.queue) > 0:
+		it = s.queue[len(s.queue)-1]
+		s.queue = s.queue[:len(s.queue)-1]
+		c.queued--
+	case len(c.queue) > 0:
+		it = c.queue[len(c.queue)-1]
+		c.queue = c.queue[:len(c.queue)-1]
+	default:
+		var victim *session
+		for other := range c.sessions {
+			if victim == nil || len(other.queue) > len(victim.queue) {
+				victim = other
+			}
+		}
+		it = victim.queue[0]
+		victim.queue = victim.queue[1:]
+		c.queued--
+		c.steals++
+	}
+	c.nextToken++
+	it.Token = c.nextToken
+	c.leases[it.Token] = &lease{
+		item:     it,
+		session:  s,
+		deadline: time.Now().Add(c.leaseTimeout()),
+	}
+	return it, true
+}
+
+func (c *Coordinator) renew(s *session, tokens []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := time.Now().Add(c.leaseTimeout())
+	for _, token := range tokens {
+		if l, ok := c.leases[token]; ok && l.session == s {
+			l.deadline = deadline
+		}
+	}
+}
+
+// errLeaseLost is returned to a worker reporting a directory that has
+// already been handed to another worker.
+var errLeaseLost = errors.New("distwalk: lease expired")
+
+// finish merges the results of a leased directory into the walk.
+func (c *Coordinator) finish(s *session, args *DoneArgs) error {
+	c.mu.Lock()
+	if c.done {
+		// The worker will learn from its next Get.
+		c.mu.Unlock()
+		return nil
+	}
+	l, ok := c.leases[args.Token]
+	if !ok || l.session != s {
+		c.mu.Unlock()
+		return errLeaseLost
+	}
+	l.finishing = true
+	c.mu.Unlock()
+
+	var subdirs []fastwalk.WorkItem
+	var err error
+	if args.Err != "" {
+		err = errors.New(args.Err)
+	} else {
+		subdirs, err = c.merge(l.item, args)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.leases, args.Token)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	if c.sessions[s] {
+		s.queue = append(s.queue, subdirs...)
+		c.queued += len(subdirs)
+	} else {
+		// The worker disconnected while its results were merged.
+		c.queue = append(c.queue, subdirs...)
+	}
+	c.cond.Broadcast()
+	c.checkDone()
+	return nil
+}
+
+// merge passes the entries of a directory to fn and returns the
+// subdirectories to walk next.
+func (c *Coordinator) merge(it fastwalk.WorkItem, args *DoneArgs) ([]fastwalk.WorkItem, error) {
+	c.fnMu.Lock()
+	defer c.fnMu.Unlock()
+
+	var subdirs []fastwalk.WorkItem
+	skipFiles := false
+	for _, we := range args.Entries {
+		if skipFiles && we.Type.IsRegular() {
+			continue
+		}
+		err := c.fn(we.entry())
+		if we.Path == it.Dir {
+			// The directory's own entry comes first.
+			if err == filepath.SkipDir {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch {
+		case err == fastwalk.ErrSkipFiles:
+			skipFiles = true
+		case we.Type == os.ModeSymlink && err == fastwalk.ErrTraverseLink:
+			subdirs = append(subdirs, fastwalk.WorkItem{Dir: we.Path, CallbackDone: true})
+		case we.Type == os.ModeSymlink && err == filepath.SkipDir:
+			// Permit SkipDir on symlinks too.
+		case err != nil:
+			return nil, err
+		}
+	}
+	for i := range args.Subdirs {
+		e := args.Subdirs[i].entry()
+		subdirs = append(subdirs, fastwalk.WorkItem{Dir: e.Path, Entry: &e})
+	}
+	return subdirs, nil
+}
+
+// session is the net/rpc service for one worker connection.
+type session struct {
+	c     *Coordinator
+	queue []fastwalk.WorkItem // found by the worker and not yet leased; guarded by c.mu
+}
+
+// GetReply is the reply to Coordinator.Get.
+type GetReply struct {
+	Item         fastwalk.WorkItem // without its Entry, which is sent as Entry
+	Entry        *WireEntry        // the directory's entry, if known
+	LeaseTimeout time.Duration
+	Done         bool // no work remains
+}
+
+// RenewArgs are the arguments to Coordinator.Renew.
+type RenewArgs struct {
+	Tokens []uint64
+}
+
+// DoneArgs are the arguments to Coordinator.Done.
+type DoneArgs struct {
+	Token   uint64
+	Err     string      // error reading the directory, if any
+	Entries []WireEntry // the directory itself, then its non-directory entries
+	Subdirs []WireEntry
+}
+
+// WireEntry is a fastwalk.Entry as sent from a worker to the
+// coordinator.
+type WireEntry struct {
+	Path       string
+	Type       os.FileMode
+	Xattrs     map[string][]byte
+	ACL        []fastwalk.ACLEntry
+	DefaultACL []fastwalk.ACLEntry
+	XattrErr   string
+}
+
+func newWireEntry(e fastwalk.Entry) WireEntry {
+	we := WireEntry{
+		Path:       e.Path,
+		Type:       e.Type,
+		Xattrs:     e.Xattrs,
+		ACL:        e.ACL,
+		DefaultACL: e.DefaultACL,
+	}
+	if e.XattrErr != nil {
+		we.XattrErr = e.XattrErr.Error()
+	}
+	return we
+}
+
+func (we *WireEntry) entry() fastwalk.Entry {
+	e := fastwalk.Entry{
+		Path:       we.Path,
+		Type:       we.Type,
+		Xattrs:     we.Xattrs,
+		ACL:        we.ACL,
+		DefaultACL: we.DefaultACL,
+	}
+	if we.XattrErr != "" {
+		e.XattrErr = errors.New(we.XattrErr)
+	}
+	return e
+}
+
+// Get leases a directory to the worker.
+func (s *session) Get(args struct{}, reply *GetReply) error {
+	it, ok := s.c.get(s)
+	if it.Entry != nil {
+		we := newWireEntry(*it.Entry)
+		reply.Entry = &we
+		it.Entry = nil
+	}
+	reply.Item, reply.Done = it, !ok
+	reply.LeaseTimeout = s.c.leaseTimeout()
+	return nil
+}
+
+// Renew extends the leases of directories the worker is still reading.
+func (s *session) Renew(args *RenewArgs, reply *struct{}) error {
+	s.c.renew(s, args.Tokens)
+	return nil
+}
+
+// Done reports the results of a leased directory.
+func (s *session) Done(args *DoneArgs, reply *struct{}) error {
+	return s.c.finish(s, args)
+}
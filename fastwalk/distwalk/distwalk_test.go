@@ -0,0 +1,271 @@
+package distwalk_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk"
+	"github.com/shanebarnes/bits/fastwalk/distwalk"
+)
+
+// makeTree creates a tree of nested directories and files and returns
+// its root with the entries fastwalk.Walk reports for it.
+func makeTree(t *testing.T) (string, map[string]os.FileMode) {
+	tempdir, err := ioutil.TempDir("", "test-distwalk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(tempdir, "root")
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 4; j++ {
+			dir := filepath.Join(root, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for k := 0; k < 3; k++ {
+				if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d", k)), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := os.Symlink("d0", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]os.FileMode{}
+	var mu sync.Mutex
+	err = fastwalk.Walk(root, func(path string, typ os.FileMode) error {
+		mu.Lock()
+		defer mu.Unlock()
+		want[path] = typ
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tempdir, want
+}
+
+type result struct {
+	err  error
+	got  map[string]os.FileMode
+	dups []string
+}
+
+func serve(c *distwalk.Coordinator, ln net.Listener, root string) chan result {
+	resc := make(chan result, 1)
+	go func() {
+		res := result{got: map[string]os.FileMode{}}
+		res.err = c.Serve(ln, root, func(e fastwalk.Entry) error {
+			if _, dup := res.got[e.Path]; dup {
+				res.dups = append(res.dups, e.Path)
+			}
+			res.got[e.Path] = e.Type
+			return nil
+		})
+		resc <- res
+	}()
+	return resc
+}
+
+func startWorkers(t *testing.T, n int, addr string) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := distwalk.Work("unix", addr, &fastwalk.Options{NumWorkers: 2}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	return &wg
+}
+
+func check(t *testing.T, res result, want map[string]os.FileMode) {
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if len(res.dups) > 0 {
+		t.Errorf("entries reported more than once: %q", res.dups)
+	}
+	if !reflect.DeepEqual(res.got, want) {
+		t.Errorf("walk mismatch:\n got %v\nwant %v", res.got, want)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tempdir, want := makeTree(t)
+	defer os.RemoveAll(tempdir)
+	addr := filepath.Join(tempdir, "sock")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resc := serve(&distwalk.Coordinator{}, ln, filepath.Join(tempdir, "root"))
+	wg := startWorkers(t, 3, addr)
+	res := <-resc
+	wg.Wait()
+	check(t, res, want)
+}
+
+func TestWalk_TraverseLinkAndSkipDir(t *testing.T) {
+	tempdir, all := makeTree(t)
+	defer os.RemoveAll(tempdir)
+	root := filepath.Join(tempdir, "root")
+	addr := filepath.Join(tempdir, "sock")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]os.FileMode{}
+	for path, typ := range all {
+		if !strings.HasPrefix(path, filepath.Join(root, "d1")+"/") {
+			want[path] = typ
+		}
+	}
+	for path, typ := range all {
+		if strings.HasPrefix(path, filepath.Join(root, "d0")+"/") {
+			want[filepath.Join(root, "link")+strings.TrimPrefix(path, filepath.Join(root, "d0"))] = typ
+		}
+	}
+
+	got := map[string]os.FileMode{}
+	done := make(chan error, 1)
+	go func() {
+		done <- (&distwalk.Coordinator{}).Serve(ln, root, func(e fastwalk.Entry) error {
+			got[e.Path] = e.Type
+			switch {
+			case e.Type == os.ModeSymlink:
+				return fastwalk.ErrTraverseLink
+			case e.Path == filepath.Join(root, "d1"):
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}()
+	wg := startWorkers(t, 2, addr)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walk mismatch:\n got %v\nwant %v", got, want)
+	}
+}
+
+// TestWalk_DeadWorker leases the root to a worker that never reports
+// back, and checks that other workers take over once the lease expires
+// or the worker disconnects.
+func TestWalk_DeadWorker(t *testing.T) {
+	for _, hang := range []bool{true, false} {
+		tempdir, want := makeTree(t)
+		addr := filepath.Join(tempdir, "sock")
+		ln, err := net.Listen("unix", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := &distwalk.Coordinator{LeaseTimeout: 200 * time.Millisecond}
+		resc := serve(c, ln, filepath.Join(tempdir, "root"))
+
+		client, err := rpc.Dial("unix", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply distwalk.GetReply
+		if err := client.Call("Coordinator.Get", struct{}{}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if !hang {
+			client.Close()
+		}
+
+		wg := startWorkers(t, 2, addr)
+		res := <-resc
+		wg.Wait()
+		check(t, res, want)
+		client.Close()
+		os.RemoveAll(tempdir)
+	}
+}
+
+// TestWalk_Stealing has one client read the root, queueing its
+// subdirectories for itself, and checks that a second client with
+// nothing to do steals the oldest of them while the first keeps taking
+// the newest. Real workers then finish the walk.
+func TestWalk_Stealing(t *testing.T) {
+	tempdir, want := makeTree(t)
+	defer os.RemoveAll(tempdir)
+	root := filepath.Join(tempdir, "root")
+	addr := filepath.Join(tempdir, "sock")
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &distwalk.Coordinator{}
+	resc := serve(c, ln, root)
+
+	dial := func() *rpc.Client {
+		client, err := rpc.Dial("unix", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	get := func(client *rpc.Client) string {
+		var reply distwalk.GetReply
+		if err := client.Call("Coordinator.Get", struct{}{}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimPrefix(reply.Item.Dir, root)
+	}
+	busy, idle := dial(), dial()
+	var reply distwalk.GetReply
+	if err := busy.Call("Coordinator.Get", struct{}{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	args := &distwalk.DoneArgs{
+		Token: reply.Item.Token,
+		Entries: []distwalk.WireEntry{
+			{Path: root, Type: os.ModeDir},
+			{Path: filepath.Join(root, "link"), Type: os.ModeSymlink},
+		},
+	}
+	for i := 0; i < 5; i++ {
+		args.Subdirs = append(args.Subdirs, distwalk.WireEntry{Path: filepath.Join(root, fmt.Sprintf("d%d", i)), Type: os.ModeDir})
+	}
+	if err := busy.Call("Coordinator.Done", args, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := get(idle); got != "/d0" {
+		t.Errorf("idle client got %s, want the oldest directory of the busy one, /d0", got)
+	}
+	if got := get(busy); got != "/d4" {
+		t.Errorf("busy client got %s, want its newest directory, /d4", got)
+	}
+	if got := c.Steals(); got != 1 {
+		t.Errorf("Steals() = %d, want 1", got)
+	}
+
+	// Leases and queues of the clients go back to the central queue.
+	busy.Close()
+	idle.Close()
+	wg := startWorkers(t, 2, addr)
+	res := <-resc
+	wg.Wait()
+	check(t, res, want)
+}
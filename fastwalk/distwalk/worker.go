@@ -0,0 +1,150 @@
+package distwalk
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// Work connects to the coordinator listening on the named network
+// address and reads directories for it until the walk is over. opts
+// configures the local walk as for fastwalk.WalkQueue; the worker's
+// entries are passed to the coordinator's callback.
+//
+// Work returns nil once the coordinator has no more work, even if the
+// walk failed; the error is reported by Coordinator.Serve.
+func Work(network, address string, opts *fastwalk.Options) error {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	q := &remoteQueue{
+		client:  rpc.NewClient(conn),
+		pending: map[uint64]*pendingDir{},
+		donec:   make(chan struct{}),
+	}
+	defer q.client.Close()
+	go q.renew()
+	defer close(q.donec)
+	return fastwalk.WalkQueue(q, opts, q.collect)
+}
+
+// remoteQueue is a fastwalk.WorkQueue backed by a coordinator.
+type remoteQueue struct {
+	client *rpc.Client
+	donec  chan struct{} // closed when the worker stops
+
+	mu      sync.Mutex
+	timeout time.Duration          // lease timeout, learned from Get
+	pending map[uint64]*pendingDir // directories being read, by lease token
+}
+
+// pendingDir holds the results of a leased directory until it is done.
+// A directory whose lease expired may be leased to the same worker
+// again while it is still read under the old lease, so the results are
+// kept by lease rather than by directory.
+type pendingDir struct {
+	entries []WireEntry
+	subdirs []WireEntry
+}
+
+func (q *remoteQueue) Get() (fastwalk.WorkItem, error) {
+	var reply GetReply
+	if err := q.client.Call(serviceName+".Get", struct{}{}, &reply); err != nil {
+		return fastwalk.WorkItem{}, err
+	}
+	if reply.Done {
+		return fastwalk.WorkItem{}, fastwalk.ErrQueueDone
+	}
+	if reply.Entry != nil {
+		e := reply.Entry.entry()
+		reply.Item.Entry = &e
+	}
+	q.mu.Lock()
+	q.timeout = reply.LeaseTimeout
+	q.pending[reply.Item.Token] = &pendingDir{}
+	q.mu.Unlock()
+	return reply.Item, nil
+}
+
+func (q *remoteQueue) Put(parent, it fastwalk.WorkItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := q.pending[parent.Token]
+	if p == nil {
+		return errors.New("distwalk: subdirectory of a directory not leased: " + it.Dir)
+	}
+	e := fastwalk.Entry{Path: it.Dir, Type: os.ModeDir}
+	if it.Entry != nil {
+		e = *it.Entry
+	}
+	p.subdirs = append(p.subdirs, newWireEntry(e))
+	return nil
+}
+
+func (q *remoteQueue) Done(it fastwalk.WorkItem, err error) error {
+	q.mu.Lock()
+	p := q.pending[it.Token]
+	delete(q.pending, it.Token)
+	q.mu.Unlock()
+
+	args := &DoneArgs{Token: it.Token}
+	if err != nil {
+		args.Err = err.Error()
+	} else if p != nil {
+		args.Entries = p.entries
+		args.Subdirs = p.subdirs
+	}
+	err = q.client.Call(serviceName+".Done", args, &struct{}{})
+	if err != nil && err.Error() == errLeaseLost.Error() {
+		// Another worker has taken over the directory.
+		return nil
+	}
+	return err
+}
+
+// collect is the callback of the local walk. It holds on to entries
+// until their directory is done, leaving all decisions to the
+// coordinator.
+func (q *remoteQueue) collect(dir fastwalk.WorkItem, e fastwalk.Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := q.pending[dir.Token]
+	if p == nil {
+		return errors.New("distwalk: entry outside leased directories: " + e.Path)
+	}
+	p.entries = append(p.entries, newWireEntry(e))
+	return nil
+}
+
+// renew periodically extends the leases of the directories being read.
+func (q *remoteQueue) renew() {
+	for {
+		q.mu.Lock()
+		interval := q.timeout / 3
+		q.mu.Unlock()
+		if interval <= 0 {
+			interval = time.Second
+		}
+		select {
+		case <-q.donec:
+			return
+		case <-time.After(interval):
+		}
+
+		args := &RenewArgs{}
+		q.mu.Lock()
+		for token := range q.pending {
+			args.Tokens = append(args.Tokens, token)
+		}
+		q.mu.Unlock()
+		if len(args.Tokens) > 0 {
+			q.client.Call(serviceName+".Renew", args, &struct{}{})
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package du computes disk usage of file trees using fastwalk.
+//
+// Each directory is charged for its own entries and for everything
+// below it, like du(1). Both the apparent size (the sum of file sizes)
+// and the allocated size (the blocks actually reserved on disk) are
+// reported. Files with several hard links are counted once.
+package du
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// Usage is the disk usage of a directory and everything below it.
+type Usage struct {
+	Path           string `json:"path"`
+	Depth          int    `json:"depth"` // 0 for the root
+	Files          int64  `json:"files"` // non-directory entries
+	Dirs           int64  `json:"dirs"`  // directories, including this one
+	ApparentBytes  int64  `json:"apparent_bytes"`
+	AllocatedBytes int64  `json:"allocated_bytes"`
+}
+
+func (u *Usage) add(v *Usage) {
+	u.Files += v.Files
+	u.Dirs += v.Dirs
+	u.ApparentBytes += v.ApparentBytes
+	u.AllocatedBytes += v.AllocatedBytes
+}
+
+// Report holds the usage of every directory of a scanned tree.
+type Report struct {
+	Root  string
+	dirs  map[string]*Usage
+	Total Usage // usage of Root
+}
+
+// Scan walks the tree rooted at root and returns the usage of each of
+// its directories. Symbolic links are counted but not followed. Entries
+// that disappear during the scan are ignored.
+func Scan(root string) (*Report, error) {
+	root = filepath.Clean(root)
+	s := scanner{
+		own:   map[string]*Usage{},
+		inode: map[fileID]bool{},
+	}
+	err := fastwalk.Walk(root, func(path string, typ os.FileMode) error {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		s.record(root, path, fi)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.report(root), nil
+}
+
+type scanner struct {
+	mu    sync.Mutex
+	own   map[string]*Usage // per-directory usage, excluding subdirectories
+	inode map[fileID]bool   // hard-linked files already counted
+}
+
+// dirUsage returns the own usage of dir, creating it if needed.
+// s.mu must be held.
+func (s *scanner) dirUsage(root, dir string) *Usage {
+	u := s.own[dir]
+	if u == nil {
+		u = &Usage{Path: dir, Depth: depth(root, dir)}
+		s.own[dir] = u
+	}
+	return u
+}
+
+func (s *scanner) record(root, path string, fi os.FileInfo) {
+	apparent := fi.Size()
+	allocated := allocatedBytes(fi)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := hardLinkID(fi); ok {
+		if s.inode[id] {
+			apparent, allocated = 0, 0
+		}
+		s.inode[id] = true
+	}
+
+	var u *Usage
+	if fi.IsDir() {
+		u = s.dirUsage(root, path)
+		u.Dirs++
+	} else {
+		u = s.dirUsage(root, parentDir(path))
+		u.Files++
+	}
+	u.ApparentBytes += apparent
+	u.AllocatedBytes += allocated
+}
+
+// report rolls the own usage of each directory up into its ancestors.
+func (s *scanner) report(root string) *Report {
+	r := &Report{Root: root, dirs: make(map[string]*Usage, len(s.own))}
+	for path, u := range s.own {
+		cu := *u
+		r.dirs[path] = &cu
+	}
+
+	paths := make([]string, 0, len(r.dirs))
+	for path := range r.dirs {
+		paths = append(paths, path)
+	}
+	// Deepest first, so each directory is complete before it is added
+	// to its parent.
+	sort.Slice(paths, func(i, j int) bool {
+		return r.dirs[paths[i]].Depth > r.dirs[paths[j]].Depth
+	})
+	for _, path := range paths {
+		if path == root {
+			continue
+		}
+		if parent, ok := r.dirs[parentDir(path)]; ok {
+			parent.add(r.dirs[path])
+		}
+	}
+	if u, ok := r.dirs[root]; ok {
+		r.Total = *u
+	}
+	return r
+}
+
+// Dirs returns the usage of every directory no deeper than maxDepth
+// below the root, sorted by path. A negative maxDepth means no limit.
+func (r *Report) Dirs(maxDepth int) []Usage {
+	var us []Usage
+	for _, u := range r.dirs {
+		if maxDepth < 0 || u.Depth <= maxDepth {
+			us = append(us, *u)
+		}
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Path < us[j].Path })
+	return us
+}
+
+// Top returns the n largest subtrees below the root by allocated size,
+// largest first. Ties are broken by apparent size, then path.
+func (r *Report) Top(n int) []Usage {
+	var us []Usage
+	for _, u := range r.dirs {
+		if u.Depth > 0 {
+			us = append(us, *u)
+		}
+	}
+	sort.Slice(us, func(i, j int) bool {
+		if us[i].AllocatedBytes != us[j].AllocatedBytes {
+			return us[i].AllocatedBytes > us[j].AllocatedBytes
+		}
+		if us[i].ApparentBytes != us[j].ApparentBytes {
+			return us[i].ApparentBytes > us[j].ApparentBytes
+		}
+		return us[i].Path < us[j].Path
+	})
+	if n >= 0 && len(us) > n {
+		us = us[:n]
+	}
+	return us
+}
+
+// parentDir returns the directory fastwalk joined with a base name to
+// form path. Unlike filepath.Dir, it does not clean the result, so it
+// matches the paths fastwalk reports for directories.
+func parentDir(path string) string {
+	if i := strings.LastIndexByte(path, os.PathSeparator); i > 0 {
+		return path[:i]
+	}
+	return path
+}
+
+func depth(root, dir string) int {
+	if dir == root {
+		return 0
+	}
+	rel := strings.TrimPrefix(dir, root)
+	return strings.Count(rel, string(os.PathSeparator))
+}
@@ -0,0 +1,17 @@
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd
+
+package du
+
+import "os"
+
+type fileID struct{}
+
+// allocatedBytes approximates the allocated size of fi with its
+// apparent size where block counts are unavailable.
+func allocatedBytes(fi os.FileInfo) int64 {
+	return fi.Size()
+}
+
+func hardLinkID(fi os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,106 @@
+package du_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shanebarnes/bits/fastwalk/du"
+)
+
+func TestScan(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-du")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	files := map[string]int{
+		"a/1":     100,
+		"a/b/2":   200,
+		"a/b/c/3": 300,
+		"d/4":     4000,
+		"5":       5,
+	}
+	for path, size := range files {
+		file := filepath.Join(tempdir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A hard link must not be counted twice.
+	if err := os.Link(filepath.Join(tempdir, "d/4"), filepath.Join(tempdir, "d/4.link")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := du.Scan(tempdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirSize := func(path string) int64 {
+		fi, err := os.Lstat(filepath.Join(tempdir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	got := map[string]du.Usage{}
+	for _, u := range r.Dirs(-1) {
+		got[strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(u.Path, tempdir)), "/")] = u
+	}
+	for _, tt := range []struct {
+		path         string
+		depth        int
+		files, dirs  int64
+		fileBytes    int64
+		dirsIncluded []string
+	}{
+		{"", 0, 6, 5, 4605, []string{"", "a", "a/b", "a/b/c", "d"}},
+		{"a", 1, 3, 3, 600, []string{"a", "a/b", "a/b/c"}},
+		{"a/b", 2, 2, 2, 500, []string{"a/b", "a/b/c"}},
+		{"a/b/c", 3, 1, 1, 300, []string{"a/b/c"}},
+		{"d", 1, 2, 1, 4000, []string{"d"}},
+	} {
+		u, ok := got[tt.path]
+		if !ok {
+			t.Errorf("missing usage for %q", tt.path)
+			continue
+		}
+		want := tt.fileBytes
+		for _, d := range tt.dirsIncluded {
+			want += dirSize(d)
+		}
+		if u.Depth != tt.depth || u.Files != tt.files || u.Dirs != tt.dirs || u.ApparentBytes != want {
+			t.Errorf("%q: got depth=%d files=%d dirs=%d bytes=%d, want depth=%d files=%d dirs=%d bytes=%d",
+				tt.path, u.Depth, u.Files, u.Dirs, u.ApparentBytes, tt.depth, tt.files, tt.dirs, want)
+		}
+		if u.AllocatedBytes < 0 {
+			t.Errorf("%q: negative allocated bytes %d", tt.path, u.AllocatedBytes)
+		}
+	}
+	if r.Total != got[""] {
+		t.Errorf("Total = %+v, want %+v", r.Total, got[""])
+	}
+
+	if n := len(r.Dirs(1)); n != 3 {
+		t.Errorf("len(Dirs(1)) = %d, want 3", n)
+	}
+	top := r.Top(2)
+	if len(top) != 2 {
+		t.Fatalf("len(Top(2)) = %d, want 2", len(top))
+	}
+	if top[0].AllocatedBytes < top[1].AllocatedBytes {
+		t.Errorf("Top not sorted: %+v", top)
+	}
+	for _, u := range top {
+		if u.Depth == 0 {
+			t.Errorf("Top included the root: %+v", u)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// +build linux darwin freebsd openbsd netbsd
+
+package du
+
+import (
+	"os"
+	"syscall"
+)
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// allocatedBytes returns the space allocated on disk for fi, which
+// st_blocks reports in 512-byte units on every supported platform.
+func allocatedBytes(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512
+	}
+	return fi.Size()
+}
+
+// hardLinkID returns the identity of fi if it is a non-directory with
+// more than one link.
+func hardLinkID(fi os.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || fi.IsDir() || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
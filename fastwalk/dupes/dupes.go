@@ -0,0 +1,298 @@
+// Package dupes finds duplicate files in a file tree using fastwalk.
+//
+// Candidate files are narrowed down in three passes so that most files
+// are never read in full:
+//   - files are grouped by size, as reported by os.Lstat;
+//   - files sharing a size are grouped by a hash of their first block;
+//   - files sharing a first-block hash are grouped by a hash of their
+//     full contents.
+//
+// Only groups with more than one member survive each pass. Hashing is
+// done by a bounded pool of workers.
+package dupes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/shanebarnes/bits/fastwalk"
+	"github.com/zeebo/blake3"
+)
+
+// Algorithm selects the hash function used to compare file contents.
+type Algorithm int
+
+const (
+	SHA256 Algorithm = iota
+	XXHash
+	BLAKE3
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case SHA256:
+		return "sha256"
+	case XXHash:
+		return "xxhash"
+	case BLAKE3:
+		return "blake3"
+	}
+	return fmt.Sprintf("Algorithm(%d)", int(a))
+}
+
+// ParseAlgorithm returns the Algorithm named s, as returned by
+// Algorithm.String.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	for _, a := range []Algorithm{SHA256, XXHash, BLAKE3} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("dupes: unknown hash algorithm %q", s)
+}
+
+func (a Algorithm) new() hash.Hash {
+	switch a {
+	case XXHash:
+		return xxhash.New()
+	case BLAKE3:
+		return blake3.New()
+	}
+	return sha256.New()
+}
+
+// ErrUnknownAlgorithm is returned by Find when Options.Algorithm is not
+// one of the defined algorithms.
+var ErrUnknownAlgorithm = errors.New("dupes: unknown hash algorithm")
+
+// DefaultPartialSize is the number of leading bytes hashed by the
+// partial pass when Options.PartialSize is zero.
+const DefaultPartialSize = 4 << 10
+
+// Options configures Find. The zero value is ready to use.
+type Options struct {
+	// Algorithm is the hash used for both the partial and full passes.
+	Algorithm Algorithm
+
+	// NumWorkers bounds the number of files hashed concurrently.
+	// If zero, runtime.NumCPU() workers are used.
+	NumWorkers int
+
+	// PartialSize is the number of leading bytes hashed in the partial
+	// pass. If zero, DefaultPartialSize is used.
+	PartialSize int64
+
+	// MinSize is the smallest file size considered. Empty files are
+	// always ignored.
+	MinSize int64
+
+	// OnError, if non-nil, is called with each file that cannot be
+	// read for hashing, such as for lack of permission. Such files are
+	// left out of the sets either way. OnError is never called
+	// concurrently.
+	OnError func(path string, err error)
+}
+
+// Set is a group of files with identical contents.
+type Set struct {
+	Size  int64    // size of each file in bytes
+	Sum   []byte   // full content hash
+	Paths []string // sorted paths of the files
+}
+
+// Find walks the file tree rooted at root and calls fn for each set of
+// duplicate regular files found. Symbolic links are not followed.
+//
+// Sets are reported largest file size first. A file that disappears
+// between the walk and hashing is silently dropped; one that cannot be
+// read is dropped and passed to opts.OnError. Hard links to the same
+// file are reported as duplicates of each other.
+//
+// If fn returns a non-nil error, Find stops and returns that error.
+func Find(root string, opts *Options, fn func(Set) error) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Algorithm < SHA256 || opts.Algorithm > BLAKE3 {
+		return ErrUnknownAlgorithm
+	}
+	f := finder{
+		alg:         opts.Algorithm,
+		numWorkers:  opts.NumWorkers,
+		partialSize: opts.PartialSize,
+		onError:     opts.OnError,
+	}
+	if f.numWorkers <= 0 {
+		f.numWorkers = runtime.NumCPU()
+	}
+	if f.partialSize <= 0 {
+		f.partialSize = DefaultPartialSize
+	}
+	minSize := opts.MinSize
+	if minSize < 1 {
+		minSize = 1
+	}
+
+	bySize, err := groupBySize(root, minSize)
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, 0, len(bySize))
+	for size, paths := range bySize {
+		if len(paths) > 1 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+
+	for _, size := range sizes {
+		for _, s := range f.sizeGroup(size, bySize[size]) {
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// groupBySize returns the paths of all regular files under root of at
+// least minSize bytes, keyed by size.
+func groupBySize(root string, minSize int64) (map[int64][]string, error) {
+	var mu sync.Mutex
+	bySize := map[int64][]string{}
+	err := fastwalk.Walk(root, func(path string, typ os.FileMode) error {
+		if !typ.IsRegular() {
+			return nil
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || fi.Size() < minSize {
+			return nil
+		}
+		mu.Lock()
+		bySize[fi.Size()] = append(bySize[fi.Size()], path)
+		mu.Unlock()
+		return nil
+	})
+	return bySize, err
+}
+
+type finder struct {
+	alg         Algorithm
+	numWorkers  int
+	partialSize int64
+	onError     func(path string, err error)
+}
+
+// sizeGroup splits paths, which all have the given size, into sets of
+// duplicates.
+func (f *finder) sizeGroup(size int64, paths []string) []Set {
+	partial := f.group(paths, f.partialSize)
+	var sets []Set
+	for _, g := range partial {
+		if size <= f.partialSize {
+			// The partial hash already covered the whole file.
+			sets = append(sets, Set{Size: size, Sum: g.sum, Paths: g.paths})
+			continue
+		}
+		full := f.group(g.paths, -1)
+		for _, fg := range full {
+			sets = append(sets, Set{Size: size, Sum: fg.sum, Paths: fg.paths})
+		}
+	}
+	return sets
+}
+
+type group struct {
+	sum   []byte
+	paths []string
+}
+
+// group hashes the first n bytes of each path (or all of it, if n < 0)
+// and returns the groups with more than one member, ordered by sum.
+// Files that cannot be hashed are left out.
+func (f *finder) group(paths []string, n int64) []group {
+	sums, errs := f.hashAll(paths, n)
+	bySum := map[string][]string{}
+	for i, sum := range sums {
+		if err := errs[i]; err != nil {
+			if !os.IsNotExist(err) && f.onError != nil {
+				f.onError(paths[i], err)
+			}
+			continue
+		}
+		bySum[string(sum)] = append(bySum[string(sum)], paths[i])
+	}
+	var groups []group
+	for sum, ps := range bySum {
+		if len(ps) > 1 {
+			sort.Strings(ps)
+			groups = append(groups, group{sum: []byte(sum), paths: ps})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return bytes.Compare(groups[i].sum, groups[j].sum) < 0
+	})
+	return groups
+}
+
+// hashAll hashes paths using at most f.numWorkers goroutines,
+// returning the sum of each path or the error hashing it.
+func (f *finder) hashAll(paths []string, n int64) ([][]byte, []error) {
+	sums := make([][]byte, len(paths))
+	errs := make([]error, len(paths))
+	workc := make(chan int)
+
+	numWorkers := f.numWorkers
+	if numWorkers > len(paths) {
+		numWorkers = len(paths)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range workc {
+				sums[i], errs[i] = f.hashFile(paths[i], n)
+			}
+		}()
+	}
+	for i := range paths {
+		workc <- i
+	}
+	close(workc)
+	wg.Wait()
+	return sums, errs
+}
+
+func (f *finder) hashFile(path string, n int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if n >= 0 {
+		r = io.LimitReader(file, n)
+	}
+	h := f.alg.new()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,130 @@
+package dupes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shanebarnes/bits/fastwalk/dupes"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	tempdir, err := ioutil.TempDir("", "test-dupes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range files {
+		file := filepath.Join(tempdir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tempdir
+}
+
+func findDupes(t *testing.T, root string, opts *dupes.Options) [][]string {
+	var got [][]string
+	err := dupes.Find(root, opts, func(s dupes.Set) error {
+		var rel []string
+		for _, p := range s.Paths {
+			rel = append(rel, filepath.ToSlash(strings.TrimPrefix(p, root)))
+		}
+		got = append(got, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestFind(t *testing.T) {
+	big := strings.Repeat("x", 3*dupes.DefaultPartialSize)
+	root := writeTree(t, map[string]string{
+		"a/one.txt":   "hello",
+		"b/one.txt":   "hello",
+		"c/two.txt":   "world",
+		"empty1":      "",
+		"empty2":      "",
+		"big/1":       big + "a",
+		"big/2":       big + "b", // same size and first block, different tail
+		"big/3":       big + "a",
+		"unique.txt":  "unique",
+		"d/e/one.txt": "hello",
+	})
+	defer os.RemoveAll(root)
+
+	want := [][]string{
+		{"/big/1", "/big/3"},
+		{"/a/one.txt", "/b/one.txt", "/d/e/one.txt"},
+	}
+	for _, alg := range []dupes.Algorithm{dupes.SHA256, dupes.XXHash, dupes.BLAKE3} {
+		got := findDupes(t, root, &dupes.Options{Algorithm: alg, NumWorkers: 2})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %q, want %q", alg, got, want)
+		}
+	}
+}
+
+func TestFind_MinSize(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"small1": "ab",
+		"small2": "ab",
+		"large1": "abcdef",
+		"large2": "abcdef",
+	})
+	defer os.RemoveAll(root)
+
+	got := findDupes(t, root, &dupes.Options{MinSize: 3})
+	want := [][]string{{"/large1", "/large2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestGroup_Unreadable checks that a file that cannot be read is left
+// out and reported, and a vanished one is only left out.
+func TestGroup_Unreadable(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a":     "same",
+		"b":     "same",
+		"dir/c": "same",
+	})
+	defer os.RemoveAll(root)
+
+	var reported []string
+	paths := []string{
+		filepath.Join(root, "a"),
+		filepath.Join(root, "dir"), // reading a directory fails
+		filepath.Join(root, "missing"),
+		filepath.Join(root, "b"),
+	}
+	got := dupes.Group(paths, func(path string, err error) {
+		reported = append(reported, strings.TrimPrefix(path, root))
+	})
+	want := [][]string{{paths[0], paths[3]}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(reported, []string{"/dir"}) {
+		t.Errorf("reported %q, want [/dir]", reported)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, alg := range []dupes.Algorithm{dupes.SHA256, dupes.XXHash, dupes.BLAKE3} {
+		got, err := dupes.ParseAlgorithm(alg.String())
+		if err != nil || got != alg {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v", alg.String(), got, err)
+		}
+	}
+	if _, err := dupes.ParseAlgorithm("md5"); err == nil {
+		t.Error("ParseAlgorithm(md5) succeeded")
+	}
+}
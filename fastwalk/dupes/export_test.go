@@ -0,0 +1,11 @@
+package dupes
+
+// Group exposes the grouping of paths by full hash to tests.
+func Group(paths []string, onError func(path string, err error)) [][]string {
+	f := &finder{numWorkers: 2, onError: onError}
+	var got [][]string
+	for _, g := range f.group(paths, -1) {
+		got = append(got, g.paths)
+	}
+	return got
+}
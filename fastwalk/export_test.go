@@ -0,0 +1,20 @@
+// +build linux darwin freebsd openbsd netbsd
+// +build !appengine
+
+package fastwalk
+
+// DirSyscalls exposes the system calls made by readDir to tests.
+type DirSyscalls = dirSyscalls
+
+// OSDirSyscalls is the real implementation of DirSyscalls.
+var OSDirSyscalls DirSyscalls = osDirSyscalls{}
+
+// SetDirSyscalls makes readDir use s until restore is called.
+func SetDirSyscalls(s DirSyscalls) (restore func()) {
+	old := sys
+	sys = s
+	return func() { sys = old }
+}
+
+// ParseDirEnt exposes parseDirEnt to tests.
+var ParseDirEnt = parseDirEnt
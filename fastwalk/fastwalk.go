@@ -8,10 +8,13 @@ package fastwalk
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrTraverseLink is used as a return value from WalkFuncs to indicate that the
@@ -23,6 +26,25 @@ var ErrTraverseLink = errors.New("fastwalk: traverse symlink, assuming target is
 // Child directories will still be traversed.
 var ErrSkipFiles = errors.New("fastwalk: skip remaining files in directory")
 
+// ErrXattrUnsupported is returned by WalkEntries when extended attribute
+// collection is requested on a platform that does not support it.
+var ErrXattrUnsupported = errors.New("fastwalk: extended attributes not supported on this platform")
+
+// CorruptDirentError is returned by the walk functions when the
+// operating system returns a malformed directory entry, such as a
+// record that is truncated, has an impossible length or a name without
+// a terminating NUL byte. Some FUSE filesystems have been known to do
+// so. The walk stops at the first corrupt entry.
+type CorruptDirentError struct {
+	Dir    string // directory being read
+	Offset int    // byte offset of the record in the ReadDirent buffer
+	Reason string
+}
+
+func (e *CorruptDirentError) Error() string {
+	return fmt.Sprintf("fastwalk: corrupt dirent in %s at offset %d: %s", e.Dir, e.Offset, e.Reason)
+}
+
 // Walk is a faster implementation of filepath.Walk.
 //
 // filepath.Walk's design necessarily calls os.Lstat on each file,
@@ -49,15 +71,122 @@ var ErrSkipFiles = errors.New("fastwalk: skip remaining files in directory")
 //     sentinel error. It is the walkFn's responsibility to prevent
 //     fastWalk from going into symlink cycles.
 func Walk(root string, walkFn func(path string, typ os.FileMode) error) error {
-	// TODO(bradfitz): make numWorkers configurable? We used a
-	// minimum of 4 to give the kernel more info about multiple
-	// things we want, in hopes its I/O scheduling can take
-	// advantage of that. Hopefully most are in cache. Maybe 4 is
-	// even too low of a minimum. Profile more.
+	return WalkEntries(root, nil, func(e Entry) error {
+		return walkFn(e.Path, e.Type)
+	})
+}
+
+// Entry is a file or directory passed to the callback of WalkEntries.
+type Entry struct {
+	Path string
+	Type os.FileMode // file type bits only, as passed to Walk's walkFn
+
+	// Xattrs holds the extended attributes of the entry, keyed by
+	// name, when Options.Xattrs is set. Symbolic links are not
+	// followed.
+	Xattrs map[string][]byte
+
+	// ACL and DefaultACL hold the decoded POSIX access and default
+	// ACLs of the entry when Options.Xattrs.ACLs is set and the entry
+	// has them. Only directories have default ACLs.
+	ACL        []ACLEntry
+	DefaultACL []ACLEntry
+
+	// XattrErr is the first error encountered reading the extended
+	// attributes or ACLs of the entry. Attributes that no longer exist
+	// and filesystems without xattr support are not errors.
+	XattrErr error
+}
+
+// Options configures WalkEntries. A nil *Options is equivalent to the
+// zero value.
+type Options struct {
+	// NumWorkers is the number of goroutines reading directories.
+	// If zero, the larger of 4 and runtime.NumCPU() is used.
+	NumWorkers int
+
+	// Xattrs, if non-nil, enables collection of extended attributes
+	// for every entry.
+	Xattrs *XattrOptions
+
+	// DirReadsPerSec, if positive, limits the rate at which
+	// directories are opened and read, on average across all workers.
+	DirReadsPerSec float64
+
+	// StatsPerSec, if positive, limits the rate of the Lstat calls
+	// made for entries whose type the directory listing does not
+	// report (DT_UNKNOWN).
+	//
+	// Neither limit applies to work done by the callback itself.
+	StatsPerSec float64
+
+	// CheckpointPath, if non-empty, names a file to which the state
+	// of the walk is saved every CheckpointInterval, and when the walk
+	// stops early because of an error, so that it can be continued
+	// with Resume. The file is removed when the walk completes.
+	CheckpointPath string
+
+	// CheckpointInterval is the time between checkpoints. If zero,
+	// DefaultCheckpointInterval is used.
+	CheckpointInterval time.Duration
+}
+
+// XattrOptions selects the extended attributes collected by
+// WalkEntries. Attributes are read relative to the already open parent
+// directory, so collecting them does not resolve each path again.
+type XattrOptions struct {
+	// Names, if non-empty, restricts collection to the named
+	// attributes (e.g. "security.selinux"), which are fetched without
+	// listing all attributes first.
+	Names []string
+
+	// ACLs enables decoding of the POSIX ACLs stored in the
+	// system.posix_acl_access and system.posix_acl_default attributes
+	// into Entry.ACL and Entry.DefaultACL.
+	ACLs bool
+}
+
+func (opts *Options) numWorkers() int {
+	if opts.NumWorkers > 0 {
+		return opts.NumWorkers
+	}
+	// TODO(bradfitz): We used a minimum of 4 to give the kernel
+	// more info about multiple things we want, in hopes its I/O
+	// scheduling can take advantage of that. Hopefully most are in
+	// cache. Maybe 4 is even too low of a minimum. Profile more.
 	numWorkers := 4
 	if n := runtime.NumCPU(); n > numWorkers {
 		numWorkers = n
 	}
+	return numWorkers
+}
+
+// WalkEntries is like Walk, but passes an Entry to fn and accepts
+// Options.
+//
+// Collecting extended attributes is only supported on Linux; on other
+// platforms WalkEntries returns ErrXattrUnsupported if opts.Xattrs is
+// set.
+func WalkEntries(root string, opts *Options, fn func(e Entry) error) error {
+	return walkEntries([]walkItem{{dir: root}}, nil, root, opts, fn, nil)
+}
+
+// walkEntries walks the directories in todo. skip holds directories
+// that must not be enqueued again because a checkpoint being resumed
+// already accounts for them.
+//
+// If yield is non-nil, fn is ignored and entries are instead passed to
+// yield on the calling goroutine. The walk stops, returning nil, when
+// yield returns false.
+func walkEntries(todo []walkItem, skip map[string]bool, root string, opts *Options, fn func(e Entry) error, yield func(e Entry) bool) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Xattrs != nil && !xattrSupported {
+		return ErrXattrUnsupported
+	}
+
+	numWorkers := opts.numWorkers()
 
 	// Make sure to wait for all workers to finish, otherwise
 	// walkFn could still be called after returning. This Wait call
@@ -66,21 +195,48 @@ func Walk(root string, walkFn func(path string, typ os.FileMode) error) error {
 	defer wg.Wait()
 
 	w := &walker{
-		fn:       walkFn,
+		fn:       fn,
+		xattrs:   opts.Xattrs,
 		enqueuec: make(chan walkItem, numWorkers), // buffered for performance
 		workc:    make(chan walkItem, numWorkers), // buffered for performance
 		donec:    make(chan struct{}),
 
 		// buffered for correctness & not leaking goroutines:
-		resc: make(chan error, numWorkers),
+		resc: make(chan walkResult, numWorkers),
+		skip: skip,
 	}
 	defer close(w.donec)
+	var entc chan Entry
+	if yield != nil {
+		entc = make(chan Entry)
+		w.fn = func(e Entry) error {
+			select {
+			case entc <- e:
+				return nil
+			case <-w.donec:
+				return errStopped
+			}
+		}
+	}
+	w.dirLimit = newLimiter(opts.DirReadsPerSec, w.donec)
+	w.statLimit = newLimiter(opts.StatsPerSec, w.donec)
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go w.doWork(&wg)
 	}
-	todo := []walkItem{{dir: root}}
+	var cp *checkpointer
+	var tickc <-chan time.Time
+	if opts.CheckpointPath != "" {
+		cp = newCheckpointer(opts.CheckpointPath, root, todo, skip)
+		interval := opts.CheckpointInterval
+		if interval <= 0 {
+			interval = DefaultCheckpointInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tickc = ticker.C
+	}
 	out := 0
 	for {
 		workc := w.workc
@@ -96,11 +252,30 @@ func Walk(root string, walkFn func(path string, typ os.FileMode) error) error {
 			out++
 		case it := <-w.enqueuec:
 			todo = append(todo, it)
-		case err := <-w.resc:
-			out--
-			if err != nil {
+			cp.enqueued(it)
+		case <-tickc:
+			todo = cp.drain(w.enqueuec, todo)
+			if err := cp.save(); err != nil {
 				return err
 			}
+		case e := <-entc:
+			if !yield(e) {
+				if cp != nil {
+					cp.drain(w.enqueuec, todo)
+					cp.save()
+				}
+				return nil
+			}
+		case res := <-w.resc:
+			out--
+			if res.err != nil {
+				if cp != nil {
+					cp.drain(w.enqueuec, todo)
+					cp.save() // report res.err rather than any save error
+				}
+				return res.err
+			}
+			cp.finished(res.dir)
 			if out == 0 && len(todo) == 0 {
 				// It's safe to quit here, as long as the buffered
 				// enqueue channel isn't also readable, which might
@@ -111,8 +286,9 @@ func Walk(root string, walkFn func(path string, typ os.FileMode) error) error {
 				select {
 				case it := <-w.enqueuec:
 					todo = append(todo, it)
+					cp.enqueued(it)
 				default:
-					return nil
+					return cp.remove()
 				}
 			}
 		}
@@ -128,50 +304,101 @@ func (w *walker) doWork(wg *sync.WaitGroup) {
 		case <-w.donec:
 			return
 		case it := <-w.workc:
+			if atomic.LoadInt32(&w.failed) != 0 {
+				// The walk is about to return. Don't start
+				// directories that a checkpoint will record as
+				// pending.
+				return
+			}
+			err := w.walk(it)
+			if err != nil {
+				atomic.StoreInt32(&w.failed, 1)
+			}
 			select {
 			case <-w.donec:
 				return
-			case w.resc <- w.walk(it.dir, !it.callbackDone):
+			case w.resc <- walkResult{dir: it.dir, err: err}:
 			}
 		}
 	}
 }
 
 type walker struct {
-	fn func(path string, typ os.FileMode) error
+	fn        func(e Entry) error
+	xattrs    *XattrOptions
+	dirLimit  *limiter
+	statLimit *limiter
 
-	donec    chan struct{} // closed on fastWalk's return
-	workc    chan walkItem // to workers
-	enqueuec chan walkItem // from workers
-	resc     chan error    // from workers
+	donec    chan struct{}   // closed on fastWalk's return
+	workc    chan walkItem   // to workers
+	enqueuec chan walkItem   // from workers
+	resc     chan walkResult // from workers
+
+	skip   map[string]bool // directories not to enqueue; read-only
+	failed int32           // set atomically once a walk returns an error
+
+	queue WorkQueue // replaces the channels above in WalkQueue
+	item  WorkItem  // the directory being read, in WalkQueue
 }
 
 type walkItem struct {
 	dir          string
 	callbackDone bool // callback already called; don't do it again
+
+	// entry is the directory's Entry, with any extended attributes
+	// read while its parent was open. If nil, it is built on demand.
+	entry *Entry
+}
+
+type walkResult struct {
+	dir string
+	err error
 }
 
-func (w *walker) enqueue(it walkItem) {
+func (w *walker) enqueue(it walkItem) error {
+	if w.skip[it.dir] {
+		return nil
+	}
+	if w.queue != nil {
+		return w.queue.Put(w.item, WorkItem{Dir: it.dir, CallbackDone: it.callbackDone, Entry: it.entry})
+	}
 	select {
 	case w.enqueuec <- it:
 	case <-w.donec:
 	}
+	return nil
 }
 
-func (w *walker) onDirEnt(dirName, baseName string, typ os.FileMode) error {
-	joined := dirName + string(os.PathSeparator) + baseName
+// entry returns the Entry for baseName in dirName, or for dirName
+// itself if baseName is empty, collecting extended attributes if
+// requested. dirfd is the open descriptor of dirName, or -1.
+func (w *walker) entry(dirName, baseName string, typ os.FileMode, dirfd int) Entry {
+	e := Entry{Path: dirName + string(os.PathSeparator) + baseName, Type: typ}
+	if baseName == "" {
+		e.Path = dirName
+	}
+	if w.xattrs != nil {
+		readXattrs(&e, dirfd, baseName, w.xattrs)
+	}
+	return e
+}
+
+func (w *walker) onDirEnt(dirName, baseName string, typ os.FileMode, dirfd int) error {
+	e := w.entry(dirName, baseName, typ, dirfd)
 	if typ == os.ModeDir {
-		w.enqueue(walkItem{dir: joined})
-		return nil
+		it := walkItem{dir: e.Path}
+		if w.xattrs != nil {
+			it.entry = &e
+		}
+		return w.enqueue(it)
 	}
 
-	err := w.fn(joined, typ)
+	err := w.fn(e)
 	if typ == os.ModeSymlink {
 		if err == ErrTraverseLink {
 			// Set callbackDone so we don't call it twice for both the
 			// symlink-as-symlink and the symlink-as-directory later:
-			w.enqueue(walkItem{dir: joined, callbackDone: true})
-			return nil
+			return w.enqueue(walkItem{dir: e.Path, callbackDone: true})
 		}
 		if err == filepath.SkipDir {
 			// Permit SkipDir on symlinks too.
@@ -181,9 +408,14 @@ func (w *walker) onDirEnt(dirName, baseName string, typ os.FileMode) error {
 	return err
 }
 
-func (w *walker) walk(root string, runUserCallback bool) error {
-	if runUserCallback {
-		err := w.fn(root, os.ModeDir)
+func (w *walker) walk(it walkItem) error {
+	if !it.callbackDone {
+		e := it.entry
+		if e == nil {
+			ent := w.entry(it.dir, "", os.ModeDir, -1)
+			e = &ent
+		}
+		err := w.fn(*e)
 		if err == filepath.SkipDir {
 			return nil
 		}
@@ -192,5 +424,6 @@ func (w *walker) walk(root string, runUserCallback bool) error {
 		}
 	}
 
-	return readDir(root, w.onDirEnt)
+	w.dirLimit.wait(1)
+	return readDir(it.dir, w.statLimit, w.onDirEnt)
 }
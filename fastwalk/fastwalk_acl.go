@@ -0,0 +1,86 @@
+package fastwalk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Names of the extended attributes holding POSIX ACLs on Linux.
+const (
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+)
+
+// ACLTag identifies whom an ACLEntry applies to.
+type ACLTag uint16
+
+// ACL entry tags, with the values used by the Linux xattr encoding.
+const (
+	ACLUserObj  ACLTag = 0x01 // file owner
+	ACLUser     ACLTag = 0x02 // user named by ID
+	ACLGroupObj ACLTag = 0x04 // file group
+	ACLGroup    ACLTag = 0x08 // group named by ID
+	ACLMask     ACLTag = 0x10 // maximum permissions for named entries and the group
+	ACLOther    ACLTag = 0x20 // everyone else
+)
+
+func (t ACLTag) String() string {
+	switch t {
+	case ACLUserObj:
+		return "user_obj"
+	case ACLUser:
+		return "user"
+	case ACLGroupObj:
+		return "group_obj"
+	case ACLGroup:
+		return "group"
+	case ACLMask:
+		return "mask"
+	case ACLOther:
+		return "other"
+	}
+	return fmt.Sprintf("ACLTag(%#x)", uint16(t))
+}
+
+// ACLEntry is one entry of a POSIX ACL.
+type ACLEntry struct {
+	Tag  ACLTag
+	ID   uint32      // uid or gid for ACLUser and ACLGroup entries
+	Perm os.FileMode // read, write and execute bits (0 to 7)
+}
+
+// ErrBadACL is returned by ParseACL for malformed input.
+var ErrBadACL = errors.New("fastwalk: malformed POSIX ACL")
+
+const (
+	aclVersion    = 2
+	aclHeaderSize = 4
+	aclEntrySize  = 8
+)
+
+// ParseACL decodes the value of a system.posix_acl_access or
+// system.posix_acl_default extended attribute as stored by Linux.
+func ParseACL(b []byte) ([]ACLEntry, error) {
+	if len(b) < aclHeaderSize || (len(b)-aclHeaderSize)%aclEntrySize != 0 {
+		return nil, ErrBadACL
+	}
+	if binary.LittleEndian.Uint32(b) != aclVersion {
+		return nil, ErrBadACL
+	}
+	b = b[aclHeaderSize:]
+	acl := make([]ACLEntry, 0, len(b)/aclEntrySize)
+	for ; len(b) > 0; b = b[aclEntrySize:] {
+		e := ACLEntry{
+			Tag:  ACLTag(binary.LittleEndian.Uint16(b[0:])),
+			Perm: os.FileMode(binary.LittleEndian.Uint16(b[2:]) & 07),
+			ID:   binary.LittleEndian.Uint32(b[4:]),
+		}
+		if e.Tag != ACLUser && e.Tag != ACLGroup {
+			e.ID = 0 // undefined; stored as ^uint32(0)
+		}
+		acl = append(acl, e)
+	}
+	return acl, nil
+}
@@ -0,0 +1,213 @@
+package fastwalk
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultCheckpointInterval is the time between checkpoints when
+// Options.CheckpointInterval is zero.
+const DefaultCheckpointInterval = time.Minute
+
+const checkpointVersion = 1
+
+// checkpointState is the gob-encoded content of a checkpoint file.
+type checkpointState struct {
+	Version int
+	Root    string
+
+	// Pending holds the directories enqueued but not yet completely
+	// read, including those that were being read.
+	Pending []checkpointItem
+
+	// Completed holds the directories that were completely read while
+	// their parent, which is pending, was still being read. They must
+	// not be enqueued again when the parent is read again.
+	Completed []string
+}
+
+type checkpointItem struct {
+	Dir          string
+	CallbackDone bool
+}
+
+// Resume continues a walk from the checkpoint file written by a
+// WalkEntries call with Options.CheckpointPath set, calling fn for the
+// entries that walk had not reached yet.
+//
+// The callback is not called again for directories that were
+// completely read before the checkpoint was saved. Directories that
+// were being read at the time may be passed to fn again, along with
+// their entries.
+//
+// opts need not match the options of the interrupted walk. To keep
+// checkpointing the resumed walk, set opts.CheckpointPath, usually to
+// the same file.
+func Resume(checkpoint string, opts *Options, fn func(e Entry) error) error {
+	st, err := loadCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+	if len(st.Pending) == 0 {
+		return nil
+	}
+	todo := make([]walkItem, 0, len(st.Pending))
+	skip := make(map[string]bool, len(st.Pending)+len(st.Completed))
+	for _, it := range st.Pending {
+		todo = append(todo, walkItem{dir: it.Dir, callbackDone: it.CallbackDone})
+		skip[it.Dir] = true
+	}
+	for _, dir := range st.Completed {
+		skip[dir] = true
+	}
+	return walkEntries(todo, skip, st.Root, opts, fn, nil)
+}
+
+func loadCheckpoint(path string) (*checkpointState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var st checkpointState
+	if err := gob.NewDecoder(f).Decode(&st); err != nil {
+		return nil, fmt.Errorf("fastwalk: reading checkpoint %s: %v", path, err)
+	}
+	if st.Version != checkpointVersion {
+		return nil, fmt.Errorf("fastwalk: checkpoint %s has unsupported version %d", path, st.Version)
+	}
+	return &st, nil
+}
+
+// checkpointer tracks the state of a walk for saving. It is only used
+// by the goroutine dispatching work, so it needs no locking. A nil
+// *checkpointer tracks nothing.
+type checkpointer struct {
+	path string
+	root string
+
+	// live holds the directories enqueued and not yet completed,
+	// whether waiting in todo or being read by a worker.
+	live map[string]walkItem
+
+	// completed holds directories completed since they were last
+	// found to have a completed parent.
+	completed map[string]bool
+}
+
+// newCheckpointer returns a checkpointer for a walk of root starting
+// with todo. skip holds the pending and completed directories of the
+// checkpoint being resumed, if any.
+func newCheckpointer(path, root string, todo []walkItem, skip map[string]bool) *checkpointer {
+	cp := &checkpointer{
+		path:      path,
+		root:      root,
+		live:      make(map[string]walkItem, len(todo)),
+		completed: map[string]bool{},
+	}
+	for _, it := range todo {
+		cp.live[it.dir] = it
+	}
+	for dir := range skip {
+		if _, ok := cp.live[dir]; !ok {
+			cp.completed[dir] = true
+		}
+	}
+	return cp
+}
+
+func (cp *checkpointer) enqueued(it walkItem) {
+	if cp != nil {
+		cp.live[it.dir] = walkItem{dir: it.dir, callbackDone: it.callbackDone}
+	}
+}
+
+func (cp *checkpointer) finished(dir string) {
+	if cp != nil {
+		delete(cp.live, dir)
+		cp.completed[dir] = true
+	}
+}
+
+// drain moves the items workers have already sent on enqueuec into
+// todo. A worker sends all of a directory's subdirectories before its
+// result, so once drained, every subdirectory of a completed directory
+// is live or completed.
+func (cp *checkpointer) drain(enqueuec chan walkItem, todo []walkItem) []walkItem {
+	for {
+		select {
+		case it := <-enqueuec:
+			todo = append(todo, it)
+			cp.enqueued(it)
+		default:
+			return todo
+		}
+	}
+}
+
+// save atomically replaces the checkpoint file with the current state.
+func (cp *checkpointer) save() error {
+	st := checkpointState{
+		Version: checkpointVersion,
+		Root:    cp.root,
+		Pending: make([]checkpointItem, 0, len(cp.live)),
+	}
+	for _, it := range cp.live {
+		st.Pending = append(st.Pending, checkpointItem{Dir: it.dir, CallbackDone: it.callbackDone})
+	}
+	for dir := range cp.completed {
+		if _, ok := cp.live[parentDir(dir)]; ok {
+			st.Completed = append(st.Completed, dir)
+		} else {
+			// Its parent is completed too, so it will never be
+			// enqueued again.
+			delete(cp.completed, dir)
+		}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(cp.path), filepath.Base(cp.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(&st)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cp.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("fastwalk: saving checkpoint: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint file of a completed walk.
+func (cp *checkpointer) remove() error {
+	if cp == nil {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// parentDir returns the directory that was joined with a base name to
+// form path.
+func parentDir(path string) string {
+	if i := strings.LastIndexByte(path, os.PathSeparator); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
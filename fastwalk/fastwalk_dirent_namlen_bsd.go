@@ -6,8 +6,19 @@
 
 package fastwalk
 
-import "syscall"
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
 
-func direntNamlen(dirent *syscall.Dirent) uint64 {
-	return uint64(dirent.Namlen)
+// direntNamlen returns the length of the name in dirent, or a reason
+// the name is malformed.
+func direntNamlen(dirent *syscall.Dirent) (uint64, string) {
+	const fixedHdr = uint64(unsafe.Offsetof(syscall.Dirent{}.Name))
+	nameLen := uint64(dirent.Namlen)
+	if nameLen > uint64(len(dirent.Name)) || fixedHdr+nameLen > uint64(dirent.Reclen) {
+		return 0, fmt.Sprintf("name length %d exceeds record length %d", nameLen, dirent.Reclen)
+	}
+	return nameLen, ""
 }
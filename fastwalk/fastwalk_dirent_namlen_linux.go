@@ -9,21 +9,27 @@ package fastwalk
 
 import (
 	"bytes"
+	"fmt"
 	"syscall"
 	"unsafe"
 )
 
-func direntNamlen(dirent *syscall.Dirent) uint64 {
+// direntNamlen returns the length of the name in dirent, or a reason
+// the name is malformed.
+func direntNamlen(dirent *syscall.Dirent) (uint64, string) {
 	const fixedHdr = uint16(unsafe.Offsetof(syscall.Dirent{}.Name))
 	nameBuf := (*[unsafe.Sizeof(dirent.Name)]byte)(unsafe.Pointer(&dirent.Name[0]))
 	const nameBufLen = uint16(len(nameBuf))
+	if dirent.Reclen < fixedHdr {
+		return 0, fmt.Sprintf("record length %d < dirent header size %d", dirent.Reclen, fixedHdr)
+	}
 	limit := dirent.Reclen - fixedHdr
 	if limit > nameBufLen {
 		limit = nameBufLen
 	}
 	nameLen := bytes.IndexByte(nameBuf[:limit], 0)
 	if nameLen < 0 {
-		panic("failed to find terminating 0 byte in dirent")
+		return 0, "failed to find terminating 0 byte in dirent"
 	}
-	return uint64(nameLen)
+	return uint64(nameLen), ""
 }
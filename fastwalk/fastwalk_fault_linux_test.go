@@ -0,0 +1,193 @@
+// +build linux
+// +build !appengine
+
+package fastwalk_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// faultSyscalls wraps the real system calls used by fastwalk's readDir
+// and injects failures.
+type faultSyscalls struct {
+	openErr  map[string]error // keyed by directory base name
+	lstatErr map[string]error // keyed by file base name
+
+	// unknownTypes rewrites every dirent type to DT_UNKNOWN, forcing
+	// the Lstat fallback.
+	unknownTypes bool
+
+	// truncate cuts each ReadDirent result in the middle of its first
+	// record.
+	truncate bool
+
+	mu    sync.Mutex
+	eintr int // number of EINTR failures left to return from ReadDirent
+}
+
+func (f *faultSyscalls) Open(path string) (int, error) {
+	if err := f.openErr[filepath.Base(path)]; err != nil {
+		return -1, err
+	}
+	return fastwalk.OSDirSyscalls.Open(path)
+}
+
+func (f *faultSyscalls) Close(fd int) error {
+	return fastwalk.OSDirSyscalls.Close(fd)
+}
+
+func (f *faultSyscalls) ReadDirent(fd int, buf []byte) (int, error) {
+	f.mu.Lock()
+	if f.eintr > 0 {
+		f.eintr--
+		f.mu.Unlock()
+		return -1, syscall.EINTR
+	}
+	f.mu.Unlock()
+
+	n, err := fastwalk.OSDirSyscalls.ReadDirent(fd, buf)
+	reclenOff := int(unsafe.Offsetof(syscall.Dirent{}.Reclen))
+	if err == nil && n > 0 && f.truncate {
+		return int(*(*uint16)(unsafe.Pointer(&buf[reclenOff]))) - 1, nil
+	}
+	if err != nil || !f.unknownTypes {
+		return n, err
+	}
+	typeOff := int(unsafe.Offsetof(syscall.Dirent{}.Type))
+	for off := 0; off < n; {
+		buf[off+typeOff] = syscall.DT_UNKNOWN
+		off += int(*(*uint16)(unsafe.Pointer(&buf[off+reclenOff])))
+	}
+	return n, nil
+}
+
+func (f *faultSyscalls) Lstat(path string) (os.FileInfo, error) {
+	if err := f.lstatErr[filepath.Base(path)]; err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: path, Err: err}
+	}
+	return fastwalk.OSDirSyscalls.Lstat(path)
+}
+
+func nopCallback(path string, typ os.FileMode) error { return nil }
+
+var faultFiles = map[string]string{
+	"foo/foo.go":   "one",
+	"bar/bar.go":   "two",
+	"bar/link":     "LINK:bar.go",
+	"skip/skip.go": "skip",
+}
+
+var faultWant = map[string]os.FileMode{
+	"":                  os.ModeDir,
+	"/src":              os.ModeDir,
+	"/src/bar":          os.ModeDir,
+	"/src/bar/bar.go":   0,
+	"/src/bar/link":     os.ModeSymlink,
+	"/src/foo":          os.ModeDir,
+	"/src/foo/foo.go":   0,
+	"/src/skip":         os.ModeDir,
+	"/src/skip/skip.go": 0,
+}
+
+func TestFaults_ReadDirentEINTR(t *testing.T) {
+	defer fastwalk.SetDirSyscalls(&faultSyscalls{eintr: 5})()
+	testFastWalk(t, faultFiles, nopCallback, faultWant)
+}
+
+func TestFaults_UnknownType(t *testing.T) {
+	defer fastwalk.SetDirSyscalls(&faultSyscalls{unknownTypes: true})()
+	testFastWalk(t, faultFiles, nopCallback, faultWant)
+}
+
+func TestFaults_UnknownTypeDeleted(t *testing.T) {
+	// The file disappears between ReadDirent and Lstat.
+	defer fastwalk.SetDirSyscalls(&faultSyscalls{
+		unknownTypes: true,
+		lstatErr:     map[string]error{"foo.go": syscall.ENOENT},
+	})()
+	want := map[string]os.FileMode{}
+	for k, v := range faultWant {
+		if k != "/src/foo/foo.go" {
+			want[k] = v
+		}
+	}
+	testFastWalk(t, faultFiles, nopCallback, want)
+}
+
+func TestFaults_UnknownTypeLstatError(t *testing.T) {
+	defer fastwalk.SetDirSyscalls(&faultSyscalls{
+		unknownTypes: true,
+		lstatErr:     map[string]error{"bar.go": syscall.EIO},
+	})()
+	err := walkTree(t, faultFiles)
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("Walk error = %v, want EIO", err)
+	}
+}
+
+func TestFaults_OpenEACCES(t *testing.T) {
+	defer fastwalk.SetDirSyscalls(&faultSyscalls{
+		openErr: map[string]error{"bar": syscall.EACCES},
+	})()
+	err := walkTree(t, faultFiles)
+	var perr *os.PathError
+	if !errors.As(err, &perr) || perr.Op != "open" || perr.Err != syscall.EACCES || !strings.HasSuffix(perr.Path, "/bar") {
+		t.Errorf("Walk error = %#v, want open EACCES on bar", err)
+	}
+}
+
+func TestFaults_TruncatedDirent(t *testing.T) {
+	defer fastwalk.SetDirSyscalls(&faultSyscalls{truncate: true})()
+	err := walkTree(t, faultFiles)
+	var derr *fastwalk.CorruptDirentError
+	if !errors.As(err, &derr) || derr.Offset != 0 {
+		t.Errorf("Walk error = %#v, want *CorruptDirentError at offset 0", err)
+	}
+}
+
+func TestParseDirEnt_Truncated(t *testing.T) {
+	reclenOff := unsafe.Offsetof(syscall.Dirent{}.Reclen)
+	ent := make([]byte, 32)
+	*(*uint16)(unsafe.Pointer(&ent[reclenOff])) = uint16(len(ent))
+	for _, buf := range [][]byte{ent[:reclenOff+1], ent[:len(ent)-1], make([]byte, 32)} {
+		if _, _, _, reason := fastwalk.ParseDirEnt(buf); reason == "" {
+			t.Errorf("parseDirEnt(%v) succeeded", buf)
+		}
+	}
+}
+
+// walkTree creates files below a temporary directory, as testFastWalk
+// does, and returns the error from walking it.
+func walkTree(t *testing.T, files map[string]string) error {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	for path, contents := range files {
+		file := filepath.Join(tempdir, "/src", path)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(contents, "LINK:") {
+			err = os.Symlink(strings.TrimPrefix(contents, "LINK:"), file)
+		} else {
+			err = ioutil.WriteFile(file, []byte(contents), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fastwalk.Walk(tempdir, nopCallback)
+}
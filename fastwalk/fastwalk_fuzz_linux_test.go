@@ -0,0 +1,69 @@
+// +build linux,go1.18
+// +build !appengine
+
+package fastwalk_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// direntSeeds returns the raw ReadDirent output for a small directory.
+func direntSeeds(f *testing.F) []byte {
+	dir, err := ioutil.TempDir("", "test-fast-walk")
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "bb", "a-much-longer-file-name.go"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		f.Fatal(err)
+	}
+	fd, err := syscall.Open(dir, 0, 0)
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	buf := make([]byte, 8<<10)
+	n, err := syscall.ReadDirent(fd, buf)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func FuzzParseDirEnt(f *testing.F) {
+	buf := direntSeeds(f)
+	f.Add(buf)
+	for _, n := range []int{0, 1, 8, 18, 19, 24, len(buf) / 2, len(buf) - 1} {
+		if n <= len(buf) {
+			f.Add(buf[:n])
+		}
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		for off := 0; off < len(buf); {
+			consumed, name, _, reason := fastwalk.ParseDirEnt(buf[off:])
+			if reason != "" {
+				return
+			}
+			if consumed <= 0 || consumed > len(buf)-off {
+				t.Fatalf("consumed %d of %d bytes at offset %d", consumed, len(buf)-off, off)
+			}
+			for i := 0; i < len(name); i++ {
+				if name[i] == 0 {
+					t.Fatalf("name %q contains NUL", name)
+				}
+			}
+			off += consumed
+		}
+	})
+}
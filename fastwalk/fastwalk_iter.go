@@ -0,0 +1,33 @@
+package fastwalk
+
+import (
+	"errors"
+	"iter"
+)
+
+// errStopped is returned by the callback that feeds All's iterator when
+// the loop over it has ended.
+var errStopped = errors.New("fastwalk: iteration stopped")
+
+// All returns an iterator over the entries of the file tree rooted at
+// root, including root, as found by WalkEntries with the same opts.
+// Entries are produced in no particular order.
+//
+// The directories are read concurrently, but the body of a range loop
+// over the iterator runs on the goroutine that started the loop, one
+// entry at a time. Leaving the loop early, with break or return, stops
+// the walk; all workers have exited by the time the loop ends.
+//
+// If the walk fails, the last pair produced holds a zero Entry and the
+// error. Directories cannot be skipped; use WalkEntries and
+// filepath.SkipDir to prune the tree.
+func All(root string, opts *Options) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		err := walkEntries([]walkItem{{dir: root}}, nil, root, opts, nil, func(e Entry) bool {
+			return yield(e, nil)
+		})
+		if err != nil {
+			yield(Entry{}, err)
+		}
+	}
+}
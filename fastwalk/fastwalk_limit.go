@@ -0,0 +1,70 @@
+package fastwalk
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket limiting the rate of filesystem operations.
+// A nil *limiter does not limit anything.
+type limiter struct {
+	donec <-chan struct{} // aborts waits when the walk ends
+
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64 // maximum number of saved tokens
+	tokens float64 // negative when callers are waiting
+	last   time.Time
+}
+
+// newLimiter returns a limiter allowing rate operations per second on
+// average, in bursts of up to a tenth of a second's worth, or nil if
+// rate is not positive.
+func newLimiter(rate float64, donec <-chan struct{}) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		donec:  donec,
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n operations may be performed, or the walk ends.
+func (l *limiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Take the tokens now, going into debt if needed, so that waiters
+	// are served in order.
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-l.donec:
+	}
+}
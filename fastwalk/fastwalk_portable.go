@@ -14,18 +14,23 @@ import (
 // readDir calls fn for each directory entry in dirName.
 // It does not descend into directories or follow symlinks.
 // If fn returns a non-nil error, readDir returns with that error
-// immediately.
-func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) error) error {
+// immediately. The directory is not held open, so fn is passed a
+// dirfd of -1.
+//
+// ioutil.ReadDir stats every entry, so each one is charged to
+// statLimit once the directory has been read.
+func readDir(dirName string, statLimit *limiter, fn func(dirName, entName string, typ os.FileMode, dirfd int) error) error {
 	fis, err := ioutil.ReadDir(dirName)
 	if err != nil {
 		return err
 	}
+	statLimit.wait(len(fis))
 	skipFiles := false
 	for _, fi := range fis {
 		if fi.Mode().IsRegular() && skipFiles {
 			continue
 		}
-		if err := fn(dirName, fi.Name(), fi.Mode()&os.ModeType); err != nil {
+		if err := fn(dirName, fi.Name(), fi.Mode()&os.ModeType, -1); err != nil {
 			if err == ErrSkipFiles {
 				skipFiles = true
 				continue
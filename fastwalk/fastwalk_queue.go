@@ -0,0 +1,118 @@
+package fastwalk
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrQueueDone is returned by WorkQueue.Get when no directories remain
+// to be walked.
+var ErrQueueDone = errors.New("fastwalk: work queue done")
+
+// WorkItem is a directory handed out by a WorkQueue.
+type WorkItem struct {
+	Dir string
+
+	// CallbackDone is set for directories reached by traversing a
+	// symlink, whose callback was already called for the symlink.
+	CallbackDone bool
+
+	// Token is an opaque value set by WorkQueue.Get and passed back
+	// to WorkQueue.Done, e.g. to identify a lease.
+	Token uint64
+
+	// Entry is the directory's Entry, with any extended attributes
+	// read while its parent was open. If nil, it is built when the
+	// directory is read.
+	Entry *Entry
+}
+
+// WorkQueue supplies the directories read by WalkQueue, in place of the
+// in-process queue of WalkEntries. Implementations may share one queue
+// among many processes. All methods must be safe for concurrent use.
+type WorkQueue interface {
+	// Get blocks until a directory is available and returns it. It
+	// returns ErrQueueDone once the walk is over.
+	Get() (WorkItem, error)
+
+	// Put adds it, a directory found while reading parent, which was
+	// obtained from Get and is not yet reported to Done.
+	Put(parent, it WorkItem) error
+
+	// Done reports that it, obtained from Get, has been read, or
+	// that reading it failed with err. Either way the queue decides
+	// whether the walk goes on.
+	Done(it WorkItem, err error) error
+}
+
+// WalkQueue walks the directories obtained from q until q.Get returns
+// ErrQueueDone, calling fn as WalkEntries does, together with the
+// directory obtained from q being read. The subdirectories of each
+// directory are passed to q.Put rather than walked directly.
+//
+// Errors from reading a directory or from fn are reported to q.Done.
+// WalkQueue returns early only if a method of q itself fails.
+func WalkQueue(q WorkQueue, opts *Options, fn func(dir WorkItem, e Entry) error) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Xattrs != nil && !xattrSupported {
+		return ErrXattrUnsupported
+	}
+	numWorkers := opts.numWorkers()
+
+	w := &walker{
+		xattrs: opts.Xattrs,
+		donec:  make(chan struct{}),
+		queue:  q,
+	}
+	w.dirLimit = newLimiter(opts.DirReadsPerSec, w.donec)
+	w.statLimit = newLimiter(opts.StatsPerSec, w.donec)
+
+	var wg sync.WaitGroup
+	errc := make(chan error, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errc <- w.workQueue(fn)
+		}()
+	}
+	wg.Wait()
+	close(w.donec)
+	close(errc)
+	for err := range errc {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workQueue reads directories from w.queue until it is done.
+func (w *walker) workQueue(fn func(dir WorkItem, e Entry) error) error {
+	for {
+		it, err := w.queue.Get()
+		if err == ErrQueueDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Read it with a walker of its own that knows the directory,
+		// so entries and subdirectories can be attributed to it.
+		iw := &walker{
+			fn:        func(e Entry) error { return fn(it, e) },
+			xattrs:    w.xattrs,
+			dirLimit:  w.dirLimit,
+			statLimit: w.statLimit,
+			donec:     w.donec,
+			queue:     w.queue,
+			item:      it,
+		}
+		err = iw.walk(walkItem{dir: it.Dir, callbackDone: it.CallbackDone, entry: it.Entry})
+		if err := w.queue.Done(it, err); err != nil {
+			return err
+		}
+	}
+}
@@ -6,10 +6,12 @@ package fastwalk_test
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -17,6 +19,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/shanebarnes/bits/fastwalk"
 )
@@ -239,6 +242,209 @@ func TestFastWalk_TraverseSymlink(t *testing.T) {
 		})
 }
 
+func TestWalkEntries_DirReadsPerSec(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+	for i := 0; i < 10; i++ {
+		if err := os.Mkdir(filepath.Join(tempdir, fmt.Sprint(i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 11 directory reads at 50/s with a burst of 5 take at least
+	// 6/50s.
+	start := time.Now()
+	var mu sync.Mutex
+	n := 0
+	err = fastwalk.WalkEntries(tempdir, &fastwalk.Options{DirReadsPerSec: 50}, func(e fastwalk.Entry) error {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 {
+		t.Errorf("walked %d directories, want 11", n)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("walk took %v, want at least 100ms", d)
+	}
+}
+
+func TestResume(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	want := map[string]bool{"": true}
+	for _, dir := range []string{"a", "a/b", "a/b/c", "d", "d/e", "f"} {
+		if err := os.Mkdir(filepath.Join(tempdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		want["/"+dir] = true
+		for i := 0; i < 3; i++ {
+			file := filepath.Join(tempdir, dir, fmt.Sprintf("%d.txt", i))
+			if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			want["/"+dir+fmt.Sprintf("/%d.txt", i)] = true
+		}
+	}
+	checkpoint := filepath.Join(tempdir, "checkpoint")
+	opts := &fastwalk.Options{NumWorkers: 1, CheckpointPath: checkpoint}
+
+	// Fail while reading the files of /d, leaving it pending.
+	errCrash := errors.New("crash")
+	first := map[string]bool{}
+	err = fastwalk.WalkEntries(tempdir, opts, func(e fastwalk.Entry) error {
+		key := strings.TrimPrefix(e.Path, tempdir)
+		if key == "/checkpoint" || strings.HasPrefix(key, "/checkpoint.tmp") {
+			return nil
+		}
+		if key == "/d/1.txt" {
+			return errCrash
+		}
+		first[key] = true
+		return nil
+	})
+	if err != errCrash {
+		t.Fatalf("WalkEntries error = %v, want %v", err, errCrash)
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatalf("checkpoint not saved: %v", err)
+	}
+
+	second := map[string]bool{}
+	err = fastwalk.Resume(checkpoint, opts, func(e fastwalk.Entry) error {
+		key := strings.TrimPrefix(e.Path, tempdir)
+		if key == "/checkpoint" || strings.HasPrefix(key, "/checkpoint.tmp") {
+			return nil
+		}
+		if second[key] {
+			t.Errorf("callback called twice for %q", key)
+		}
+		second[key] = true
+		if first[key] && e.Type == os.ModeDir && key != "/d" {
+			t.Errorf("callback called again for completed directory %q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed after completion: %v", err)
+	}
+
+	for key := range second {
+		first[key] = true
+	}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("walk mismatch.\n got: %v\nwant: %v", first, want)
+	}
+}
+
+func TestResume_PeriodicCheckpoint(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-resume-periodic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	want := map[string]bool{"": true}
+	for _, dir := range []string{"a", "a/b", "a/b/c", "d", "d/e", "d/e/f", "g"} {
+		if err := os.Mkdir(filepath.Join(tempdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		want["/"+dir] = true
+		for i := 0; i < 3; i++ {
+			file := filepath.Join(tempdir, dir, fmt.Sprintf("%d.txt", i))
+			if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			want["/"+dir+fmt.Sprintf("/%d.txt", i)] = true
+		}
+	}
+	checkpoint := filepath.Join(tempdir, "checkpoint")
+	snapshot := filepath.Join(t.TempDir(), "snapshot")
+	opts := &fastwalk.Options{NumWorkers: 1, CheckpointPath: checkpoint, CheckpointInterval: time.Millisecond}
+	ignore := func(key string) bool {
+		return key == "/checkpoint" || strings.HasPrefix(key, "/checkpoint.tmp")
+	}
+
+	// While reading the files of /d, wait for two periodic checkpoints,
+	// so that the second is saved after the callback was called, and
+	// keep it as a crash would. The checkpoint saved on the error path
+	// is not used.
+	errCrash := errors.New("crash")
+	first := map[string]bool{}
+	err = fastwalk.WalkEntries(tempdir, opts, func(e fastwalk.Entry) error {
+		key := strings.TrimPrefix(e.Path, tempdir)
+		if ignore(key) {
+			return nil
+		}
+		first[key] = true
+		if key != "/d/1.txt" {
+			return nil
+		}
+		for i := 0; i < 2; i++ {
+			os.Remove(checkpoint)
+			for {
+				if _, err := os.Stat(checkpoint); err == nil {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		data, err := ioutil.ReadFile(checkpoint)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(snapshot, data, 0644); err != nil {
+			return err
+		}
+		return errCrash
+	})
+	if err != errCrash {
+		t.Fatalf("WalkEntries error = %v, want %v", err, errCrash)
+	}
+
+	// Only /d, which was being read, and its files may be visited again.
+	second := map[string]bool{}
+	err = fastwalk.Resume(snapshot, &fastwalk.Options{NumWorkers: 1}, func(e fastwalk.Entry) error {
+		key := strings.TrimPrefix(e.Path, tempdir)
+		if ignore(key) {
+			return nil
+		}
+		if second[key] {
+			t.Errorf("callback called twice for %q", key)
+		}
+		second[key] = true
+		again := key == "/d" || (path.Dir(key) == "/d" && e.Type.IsRegular())
+		if first[key] && !again {
+			t.Errorf("callback called again for %q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key := range second {
+		first[key] = true
+	}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("walk mismatch.\n got: %v\nwant: %v", first, want)
+	}
+}
+
 var benchDir = flag.String("benchdir", runtime.GOROOT(), "The directory to scan for BenchmarkFastWalk")
 
 func BenchmarkFastWalk(b *testing.B) {
@@ -250,3 +456,92 @@ func BenchmarkFastWalk(b *testing.B) {
 		}
 	}
 }
+
+func TestAll(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	want := map[string]os.FileMode{"": os.ModeDir}
+	for _, dir := range []string{"a", "a/b", "c"} {
+		if err := os.Mkdir(filepath.Join(tempdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		want["/"+dir] = os.ModeDir
+		for i := 0; i < 10; i++ {
+			file := filepath.Join(tempdir, dir, fmt.Sprintf("%d.txt", i))
+			if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			want["/"+dir+fmt.Sprintf("/%d.txt", i)] = 0
+		}
+	}
+
+	got := map[string]os.FileMode{}
+	for e, err := range fastwalk.All(tempdir, nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[strings.TrimPrefix(e.Path, tempdir)] = e.Type
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walk mismatch.\n got:\n%v\nwant:\n%v", formatFileModes(got), formatFileModes(want))
+	}
+
+	// Breaking out of the loop stops the workers before the loop ends.
+	before := runtime.NumGoroutine()
+	n := 0
+	for _, err := range fastwalk.All(tempdir, &fastwalk.Options{NumWorkers: 8}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n++; n == 5 {
+			break
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines running after break, want %d", after, before)
+	}
+
+	// A failing walk ends with the error.
+	var last error
+	for _, err := range fastwalk.All(filepath.Join(tempdir, "missing"), nil) {
+		last = err
+	}
+	if !os.IsNotExist(last) {
+		t.Errorf("last error = %v, want not exist", last)
+	}
+}
+
+func TestParseACL(t *testing.T) {
+	acl := []byte{
+		2, 0, 0, 0, // version
+		0x01, 0, 6, 0, 0xff, 0xff, 0xff, 0xff, // user_obj rw-
+		0x02, 0, 4, 0, 0xe8, 0x03, 0, 0, // user 1000 r--
+		0x04, 0, 5, 0, 0xff, 0xff, 0xff, 0xff, // group_obj r-x
+		0x10, 0, 7, 0, 0xff, 0xff, 0xff, 0xff, // mask rwx
+		0x20, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, // other ---
+	}
+	got, err := fastwalk.ParseACL(acl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fastwalk.ACLEntry{
+		{Tag: fastwalk.ACLUserObj, Perm: 6},
+		{Tag: fastwalk.ACLUser, ID: 1000, Perm: 4},
+		{Tag: fastwalk.ACLGroupObj, Perm: 5},
+		{Tag: fastwalk.ACLMask, Perm: 7},
+		{Tag: fastwalk.ACLOther, Perm: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseACL = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range [][]byte{nil, {2, 0, 0}, {1, 0, 0, 0}, acl[:len(acl)-1]} {
+		if _, err := fastwalk.ParseACL(bad); err != fastwalk.ErrBadACL {
+			t.Errorf("ParseACL(%v) error = %v, want ErrBadACL", bad, err)
+		}
+	}
+}
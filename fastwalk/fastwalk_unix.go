@@ -20,12 +20,48 @@ const blockSize = 8 << 10
 // value used to represent a syscall.DT_UNKNOWN Dirent.Type.
 const unknownFileMode os.FileMode = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice
 
-func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) error) error {
-	fd, err := syscall.Open(dirName, 0, 0)
+// dirSyscalls are the system calls made by readDir. Tests replace
+// sys with an implementation that injects faults.
+type dirSyscalls interface {
+	Open(path string) (fd int, err error)
+	Close(fd int) error
+	ReadDirent(fd int, buf []byte) (n int, err error)
+	Lstat(path string) (os.FileInfo, error)
+}
+
+type osDirSyscalls struct{}
+
+func (osDirSyscalls) Open(path string) (int, error) {
+	return syscall.Open(path, 0, 0)
+}
+
+func (osDirSyscalls) Close(fd int) error {
+	return syscall.Close(fd)
+}
+
+func (osDirSyscalls) ReadDirent(fd int, buf []byte) (int, error) {
+	return syscall.ReadDirent(fd, buf)
+}
+
+func (osDirSyscalls) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+var sys dirSyscalls = osDirSyscalls{}
+
+func readDir(dirName string, statLimit *limiter, fn func(dirName, entName string, typ os.FileMode, dirfd int) error) error {
+	var fd int
+	var err error
+	for {
+		fd, err = sys.Open(dirName)
+		if err != syscall.EINTR {
+			break
+		}
+	}
 	if err != nil {
 		return &os.PathError{Op: "open", Path: dirName, Err: err}
 	}
-	defer syscall.Close(fd)
+	defer sys.Close(fd)
 
 	// The buffer must be at least a block long.
 	buf := make([]byte, blockSize) // stack-allocated; doesn't escape
@@ -35,7 +71,11 @@ func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) e
 	for {
 		if bufp >= nbuf {
 			bufp = 0
-			nbuf, err = syscall.ReadDirent(fd, buf)
+			nbuf, err = sys.ReadDirent(fd, buf)
+			if err == syscall.EINTR {
+				nbuf = 0 // read again
+				continue
+			}
 			if err != nil {
 				return os.NewSyscallError("readdirent", err)
 			}
@@ -43,7 +83,10 @@ func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) e
 				return nil
 			}
 		}
-		consumed, name, typ := parseDirEnt(buf[bufp:nbuf])
+		consumed, name, typ, reason := parseDirEnt(buf[bufp:nbuf])
+		if reason != "" {
+			return &CorruptDirentError{Dir: dirName, Offset: bufp, Reason: reason}
+		}
 		bufp += consumed
 		if name == "" || name == "." || name == ".." {
 			continue
@@ -52,7 +95,8 @@ func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) e
 		// support Dirent.Type and have DT_UNKNOWN (0) there
 		// instead.
 		if typ == unknownFileMode {
-			fi, err := os.Lstat(dirName + "/" + name)
+			statLimit.wait(1)
+			fi, err := sys.Lstat(dirName + "/" + name)
 			if err != nil {
 				// It got deleted in the meantime.
 				if os.IsNotExist(err) {
@@ -65,7 +109,7 @@ func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) e
 		if skipFiles && typ.IsRegular() {
 			continue
 		}
-		if err := fn(dirName, name, typ); err != nil {
+		if err := fn(dirName, name, typ, fd); err != nil {
 			if err == ErrSkipFiles {
 				skipFiles = true
 				continue
@@ -75,15 +119,21 @@ func readDir(dirName string, fn func(dirName, entName string, typ os.FileMode) e
 	}
 }
 
-func parseDirEnt(buf []byte) (consumed int, name string, typ os.FileMode) {
+// parseDirEnt parses the first record in buf. If the record is
+// malformed, reason describes the problem and the other results are
+// meaningless.
+func parseDirEnt(buf []byte) (consumed int, name string, typ os.FileMode, reason string) {
 	// golang.org/issue/37269
 	dirent := &syscall.Dirent{}
 	copy((*[unsafe.Sizeof(syscall.Dirent{})]byte)(unsafe.Pointer(dirent))[:], buf)
 	if v := unsafe.Offsetof(dirent.Reclen) + unsafe.Sizeof(dirent.Reclen); uintptr(len(buf)) < v {
-		panic(fmt.Sprintf("buf size of %d smaller than dirent header size %d", len(buf), v))
+		return 0, "", 0, fmt.Sprintf("buf size of %d smaller than dirent header size %d", len(buf), v)
+	}
+	if dirent.Reclen == 0 {
+		return 0, "", 0, "zero record length"
 	}
 	if len(buf) < int(dirent.Reclen) {
-		panic(fmt.Sprintf("buf size %d < record length %d", len(buf), dirent.Reclen))
+		return 0, "", 0, fmt.Sprintf("buf size %d < record length %d", len(buf), dirent.Reclen)
 	}
 	consumed = int(dirent.Reclen)
 	if direntInode(dirent) == 0 { // File absent in directory.
@@ -114,7 +164,10 @@ func parseDirEnt(buf []byte) (consumed int, name string, typ os.FileMode) {
 	}
 
 	nameBuf := (*[unsafe.Sizeof(dirent.Name)]byte)(unsafe.Pointer(&dirent.Name[0]))
-	nameLen := direntNamlen(dirent)
+	nameLen, reason := direntNamlen(dirent)
+	if reason != "" {
+		return 0, "", 0, reason
+	}
 
 	// Special cases for common things:
 	if nameLen == 1 && nameBuf[0] == '.' {
@@ -0,0 +1,189 @@
+// +build linux
+// +build !appengine
+
+package fastwalk
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const xattrSupported = true
+
+var (
+	procSelfFDOnce sync.Once
+	procSelfFDOK   bool
+)
+
+// xattrPath returns the path used to read the extended attributes of e.
+// When the parent directory is open, the entry is named through the
+// directory's descriptor in /proc/self/fd, so the kernel does not have
+// to resolve every component of e.Path again.
+func xattrPath(e *Entry, dirfd int, baseName string) string {
+	procSelfFDOnce.Do(func() {
+		_, err := os.Stat("/proc/self/fd")
+		procSelfFDOK = err == nil
+	})
+	if dirfd < 0 || baseName == "" || !procSelfFDOK {
+		return e.Path
+	}
+	return "/proc/self/fd/" + strconv.Itoa(dirfd) + "/" + baseName
+}
+
+// readXattrs fills in the extended attributes and ACLs of e as selected
+// by opts. Failures are recorded in e.XattrErr.
+func readXattrs(e *Entry, dirfd int, baseName string, opts *XattrOptions) {
+	path, err := syscall.BytePtrFromString(xattrPath(e, dirfd, baseName))
+	if err != nil {
+		e.XattrErr = err
+		return
+	}
+	setErr := func(op string, err error) {
+		if e.XattrErr == nil {
+			e.XattrErr = &os.PathError{Op: op, Path: e.Path, Err: err}
+		}
+	}
+
+	names := opts.Names
+	if len(names) == 0 {
+		list, err := getSized(func(buf []byte) (int, error) {
+			return llistxattr(path, buf)
+		})
+		if err != nil {
+			if !ignoreXattrErr(err) {
+				setErr("listxattr", err)
+			}
+			return
+		}
+		names = splitNames(list)
+	}
+	for _, name := range names {
+		v, err := lgetxattr(path, name)
+		if err != nil {
+			if !ignoreXattrErr(err) {
+				setErr("getxattr", err)
+			}
+			continue
+		}
+		if e.Xattrs == nil {
+			e.Xattrs = make(map[string][]byte, len(names))
+		}
+		e.Xattrs[name] = v
+	}
+
+	if !opts.ACLs || e.Type == os.ModeSymlink {
+		return
+	}
+	acls := []struct {
+		name string
+		dst  *[]ACLEntry
+	}{
+		{xattrACLAccess, &e.ACL},
+		{xattrACLDefault, &e.DefaultACL},
+	}
+	for _, acl := range acls {
+		if acl.name == xattrACLDefault && e.Type != os.ModeDir {
+			continue
+		}
+		v, ok := e.Xattrs[acl.name]
+		if !ok {
+			if v, err = lgetxattr(path, acl.name); err != nil {
+				if !ignoreXattrErr(err) {
+					setErr("getxattr", err)
+				}
+				continue
+			}
+		}
+		if *acl.dst, err = ParseACL(v); err != nil {
+			setErr("getxattr", err)
+		}
+	}
+}
+
+// ignoreXattrErr reports whether err means there is simply nothing to
+// read: the attribute or file is gone, or the filesystem has no xattrs.
+func ignoreXattrErr(err error) bool {
+	switch err {
+	case syscall.ENODATA, syscall.ENOENT, syscall.ENOTSUP:
+		return true
+	}
+	return false
+}
+
+func splitNames(list []byte) []string {
+	var names []string
+	for len(list) > 0 {
+		i := 0
+		for i < len(list) && list[i] != 0 {
+			i++
+		}
+		if i > 0 {
+			names = append(names, string(list[:i]))
+		}
+		if i < len(list) {
+			i++
+		}
+		list = list[i:]
+	}
+	return names
+}
+
+// getSized calls fn with a buffer large enough for its result, as
+// reported by calling it with an empty buffer first.
+func getSized(fn func(buf []byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := fn(nil)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return []byte{}, nil
+		}
+		buf := make([]byte, n)
+		n, err = fn(buf)
+		if err == syscall.ERANGE {
+			// It grew in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func lgetxattr(path *byte, name string) ([]byte, error) {
+	attr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	return getSized(func(buf []byte) (int, error) {
+		var p unsafe.Pointer
+		if len(buf) > 0 {
+			p = unsafe.Pointer(&buf[0])
+		}
+		r, _, errno := syscall.Syscall6(syscall.SYS_LGETXATTR,
+			uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(attr)),
+			uintptr(p), uintptr(len(buf)), 0, 0)
+		if errno != 0 {
+			return 0, errno
+		}
+		return int(r), nil
+	})
+}
+
+func llistxattr(path *byte, buf []byte) (int, error) {
+	var p unsafe.Pointer
+	if len(buf) > 0 {
+		p = unsafe.Pointer(&buf[0])
+	}
+	r, _, errno := syscall.Syscall(syscall.SYS_LLISTXATTR,
+		uintptr(unsafe.Pointer(path)), uintptr(p), uintptr(len(buf)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
@@ -0,0 +1,211 @@
+// +build linux
+// +build !appengine
+
+package fastwalk_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+func TestWalkEntries_Xattrs(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	for _, dir := range []string{"dir", "dir/sub"} {
+		if err := os.Mkdir(filepath.Join(tempdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"dir/a", "dir/sub/b"} {
+		if err := ioutil.WriteFile(filepath.Join(tempdir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attrs := map[string]map[string]string{
+		"/dir":       {"user.label": "d", "user.other": "x"},
+		"/dir/a":     {"user.label": "a"},
+		"/dir/sub/b": {"user.label": "b", "user.other": "y"},
+	}
+	for file, kvs := range attrs {
+		for k, v := range kvs {
+			if err := syscall.Setxattr(tempdir+file, k, []byte(v), 0); err != nil {
+				if err == syscall.ENOTSUP {
+					t.Skipf("skipping because user xattrs are unsupported in %s", tempdir)
+				}
+				t.Fatal(err)
+			}
+		}
+	}
+
+	collect := func(opts *fastwalk.XattrOptions) map[string]map[string]string {
+		var mu sync.Mutex
+		got := map[string]map[string]string{}
+		err := fastwalk.WalkEntries(tempdir, &fastwalk.Options{Xattrs: opts}, func(e fastwalk.Entry) error {
+			if e.XattrErr != nil {
+				t.Errorf("%s: %v", e.Path, e.XattrErr)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for k, v := range e.Xattrs {
+				if !strings.HasPrefix(k, "user.") {
+					continue // e.g. security.selinux
+				}
+				key := strings.TrimPrefix(e.Path, tempdir)
+				if got[key] == nil {
+					got[key] = map[string]string{}
+				}
+				got[key][k] = string(v)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := collect(&fastwalk.XattrOptions{}); !reflect.DeepEqual(got, attrs) {
+		t.Errorf("all xattrs: got %v, want %v", got, attrs)
+	}
+
+	want := map[string]map[string]string{
+		"/dir":       {"user.other": "x"},
+		"/dir/sub/b": {"user.other": "y"},
+	}
+	if got := collect(&fastwalk.XattrOptions{Names: []string{"user.other", "user.missing"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("named xattrs: got %v, want %v", got, want)
+	}
+}
+
+func TestWalkEntries_ACLs(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	file := filepath.Join(tempdir, "a")
+	if err := ioutil.WriteFile(file, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	acl := []byte{
+		2, 0, 0, 0,
+		0x01, 0, 6, 0, 0xff, 0xff, 0xff, 0xff, // user_obj rw-
+		0x02, 0, 4, 0, 0xe8, 0x03, 0, 0, // user 1000 r--
+		0x04, 0, 4, 0, 0xff, 0xff, 0xff, 0xff, // group_obj r--
+		0x10, 0, 4, 0, 0xff, 0xff, 0xff, 0xff, // mask r--
+		0x20, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, // other ---
+	}
+	if err := syscall.Setxattr(file, "system.posix_acl_access", acl, 0); err != nil {
+		if err == syscall.ENOTSUP {
+			t.Skipf("skipping because POSIX ACLs are unsupported in %s", tempdir)
+		}
+		t.Fatal(err)
+	}
+
+	var got []fastwalk.ACLEntry
+	opts := &fastwalk.Options{Xattrs: &fastwalk.XattrOptions{Names: []string{"user.none"}, ACLs: true}}
+	err = fastwalk.WalkEntries(tempdir, opts, func(e fastwalk.Entry) error {
+		if e.XattrErr != nil {
+			t.Errorf("%s: %v", e.Path, e.XattrErr)
+		}
+		if e.Path == file {
+			got = e.ACL
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fastwalk.ACLEntry{
+		{Tag: fastwalk.ACLUserObj, Perm: 6},
+		{Tag: fastwalk.ACLUser, ID: 1000, Perm: 4},
+		{Tag: fastwalk.ACLGroupObj, Perm: 4},
+		{Tag: fastwalk.ACLMask, Perm: 4},
+		{Tag: fastwalk.ACLOther, Perm: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ACL = %+v, want %+v", got, want)
+	}
+}
+
+// sliceQueue is a fastwalk.WorkQueue for a single worker.
+type sliceQueue struct {
+	todo []fastwalk.WorkItem
+	put  func(it fastwalk.WorkItem)
+}
+
+func (q *sliceQueue) Get() (fastwalk.WorkItem, error) {
+	if len(q.todo) == 0 {
+		return fastwalk.WorkItem{}, fastwalk.ErrQueueDone
+	}
+	it := q.todo[len(q.todo)-1]
+	q.todo = q.todo[:len(q.todo)-1]
+	return it, nil
+}
+
+func (q *sliceQueue) Put(parent, it fastwalk.WorkItem) error {
+	q.put(it)
+	q.todo = append(q.todo, it)
+	return nil
+}
+
+func (q *sliceQueue) Done(it fastwalk.WorkItem, err error) error { return err }
+
+// TestWalkQueue_Xattrs checks that the extended attributes of a
+// directory, read while its parent is open, travel with it through the
+// queue rather than being read again.
+func TestWalkQueue_Xattrs(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "test-fast-walk-xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	dir := filepath.Join(tempdir, "dir")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(dir, "user.label", []byte("d"), 0); err != nil {
+		if err == syscall.ENOTSUP {
+			t.Skipf("skipping because user xattrs are unsupported in %s", tempdir)
+		}
+		t.Fatal(err)
+	}
+
+	q := &sliceQueue{todo: []fastwalk.WorkItem{{Dir: tempdir}}}
+	q.put = func(it fastwalk.WorkItem) {
+		if it.Entry == nil || string(it.Entry.Xattrs["user.label"]) != "d" {
+			t.Errorf("Put(%s) without the directory's xattrs: %+v", it.Dir, it.Entry)
+		}
+		// A directory read again would now have no label.
+		if err := syscall.Removexattr(it.Dir, "user.label"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got string
+	err = fastwalk.WalkQueue(q, &fastwalk.Options{NumWorkers: 1, Xattrs: &fastwalk.XattrOptions{}}, func(_ fastwalk.WorkItem, e fastwalk.Entry) error {
+		if e.Path == dir {
+			got = string(e.Xattrs["user.label"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "d" {
+		t.Errorf("user.label of %s = %q, want %q", dir, got, "d")
+	}
+}
@@ -0,0 +1,7 @@
+// +build appengine !linux
+
+package fastwalk
+
+const xattrSupported = false
+
+func readXattrs(e *Entry, dirfd int, baseName string, opts *XattrOptions) {}
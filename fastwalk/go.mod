@@ -1,3 +1,11 @@
 module github.com/shanebarnes/bits/fastwalk
 
-go 1.14
+go 1.23
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/dustin/go-humanize v1.0.1
+	github.com/zeebo/blake3 v0.2.4
+)
+
+require github.com/klauspost/cpuid/v2 v2.0.12 // indirect
@@ -0,0 +1,378 @@
+// Package manifest exports listings of file trees and verifies trees
+// against them.
+//
+// A manifest holds one Entry per file, directory or other object in the
+// tree. Manifests are streamed as JSON Lines, CSV or BSD mtree(5) text
+// while the tree is walked with fastwalk, so entries appear in no
+// particular order.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// Format selects the encoding of a manifest.
+type Format int
+
+const (
+	JSONL Format = iota
+	CSV
+	Mtree
+)
+
+func (f Format) String() string {
+	switch f {
+	case JSONL:
+		return "jsonl"
+	case CSV:
+		return "csv"
+	case Mtree:
+		return "mtree"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the Format named s, as returned by Format.String.
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{JSONL, CSV, Mtree} {
+		if f.String() == s {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("manifest: unknown format %q", s)
+}
+
+// Entry describes one object in a tree.
+type Entry struct {
+	// Path is the slash-separated path relative to the root of the
+	// tree. The root itself is ".".
+	Path    string
+	Type    Type
+	Size    int64       // regular files only
+	Mode    os.FileMode // permission and setuid/setgid/sticky bits
+	UID     uint32
+	GID     uint32
+	ModTime time.Time
+	Link    string // symlink target
+	SHA256  string // hex digest of regular files, if requested
+}
+
+// Type is the kind of an Entry, named as in mtree(5).
+type Type string
+
+const (
+	TypeFile   Type = "file"
+	TypeDir    Type = "dir"
+	TypeLink   Type = "link"
+	TypeBlock  Type = "block"
+	TypeChar   Type = "char"
+	TypeFifo   Type = "fifo"
+	TypeSocket Type = "socket"
+)
+
+func typeOf(mode os.FileMode) Type {
+	switch {
+	case mode.IsRegular():
+		return TypeFile
+	case mode&os.ModeDir != 0:
+		return TypeDir
+	case mode&os.ModeSymlink != 0:
+		return TypeLink
+	case mode&os.ModeCharDevice != 0:
+		return TypeChar
+	case mode&os.ModeDevice != 0:
+		return TypeBlock
+	case mode&os.ModeNamedPipe != 0:
+		return TypeFifo
+	case mode&os.ModeSocket != 0:
+		return TypeSocket
+	}
+	return Type("unknown")
+}
+
+// Writer encodes entries in one of the manifest formats.
+type Writer interface {
+	Write(e *Entry) error
+	// Flush writes any buffered data to the underlying io.Writer.
+	Flush() error
+}
+
+// NewWriter returns a Writer encoding entries to w in format f.
+func NewWriter(w io.Writer, f Format) (Writer, error) {
+	switch f {
+	case JSONL:
+		return newJSONLWriter(w), nil
+	case CSV:
+		return newCSVWriter(w), nil
+	case Mtree:
+		return newMtreeWriter(w), nil
+	}
+	return nil, fmt.Errorf("manifest: unknown format %v", f)
+}
+
+// Reader decodes entries written by a Writer of the same format.
+type Reader interface {
+	// Read returns the next entry, or io.EOF at the end of the input.
+	Read() (*Entry, error)
+}
+
+// NewReader returns a Reader decoding entries from r in format f.
+func NewReader(r io.Reader, f Format) (Reader, error) {
+	switch f {
+	case JSONL:
+		return newJSONLReader(r), nil
+	case CSV:
+		return newCSVReader(r), nil
+	case Mtree:
+		return newMtreeReader(r), nil
+	}
+	return nil, fmt.Errorf("manifest: unknown format %v", f)
+}
+
+// Options configures Export and Verify.
+type Options struct {
+	// Checksum enables SHA-256 digests of regular files. Verify always
+	// checks digests present in the manifest.
+	Checksum bool
+}
+
+// Export walks the tree rooted at root and writes an entry for each
+// object in it to w. Symbolic links are recorded but not followed.
+// Objects that disappear during the walk are left out.
+func Export(root string, w Writer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var mu sync.Mutex
+	err := walk(root, opts.Checksum, func(e *Entry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return w.Write(e)
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// walk calls fn with the entry of each object under root.
+func walk(root string, checksum bool, fn func(e *Entry) error) error {
+	return fastwalk.Walk(root, func(path string, typ os.FileMode) error {
+		e, err := stat(root, path, checksum)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return fn(e)
+	})
+}
+
+// stat returns the entry of path, which is root or a path below it.
+func stat(root, path string, checksum bool) (*Entry, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		Path:    relPath(root, path),
+		Type:    typeOf(fi.Mode()),
+		Mode:    fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+		ModTime: fi.ModTime(),
+	}
+	e.UID, e.GID = owner(fi)
+	switch e.Type {
+	case TypeFile:
+		e.Size = fi.Size()
+		if checksum {
+			if e.SHA256, err = fileSHA256(path); err != nil {
+				return nil, err
+			}
+		}
+	case TypeLink:
+		if e.Link, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func relPath(root, path string) string {
+	if path == root {
+		return "."
+	}
+	return filepath.ToSlash(strings.TrimPrefix(path, root+string(os.PathSeparator)))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Mismatch describes a difference between a manifest and a live tree.
+type Mismatch struct {
+	Path  string
+	Field string // "missing", "extra" or the name of the differing field
+	Want  string // value recorded in the manifest
+	Got   string // value found in the tree
+}
+
+func (m Mismatch) String() string {
+	switch m.Field {
+	case "missing":
+		return fmt.Sprintf("%s: missing", m.Path)
+	case "extra":
+		return fmt.Sprintf("%s: extra", m.Path)
+	}
+	return fmt.Sprintf("%s: %s: want %s, got %s", m.Path, m.Field, m.Want, m.Got)
+}
+
+// Verify compares the tree rooted at root with the manifest read from
+// r and calls fn for each difference found: entries missing from the
+// tree, objects not in the manifest and fields that differ. Digests are
+// compared for entries that have one. Differences are reported in no
+// particular order.
+//
+// If fn returns a non-nil error, Verify stops and returns that error.
+func Verify(root string, r Reader, fn func(Mismatch) error) error {
+	want := map[string]*Entry{}
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		want[e.Path] = e
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool, len(want))
+	err := fastwalk.Walk(root, func(path string, typ os.FileMode) error {
+		rel := relPath(root, path)
+		w, ok := want[rel]
+		if !ok {
+			return report(&mu, fn, Mismatch{Path: rel, Field: "extra"})
+		}
+		got, err := stat(root, path, w.SHA256 != "")
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Vanished since it was walked; reported missing
+				// below.
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		seen[rel] = true
+		mu.Unlock()
+		for _, m := range compare(w, got) {
+			if err := report(&mu, fn, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for path := range want {
+		if !seen[path] {
+			if err := fn(Mismatch{Path: path, Field: "missing"}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func report(mu *sync.Mutex, fn func(Mismatch) error, m Mismatch) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return fn(m)
+}
+
+// compare returns the fields of got that differ from want.
+func compare(want, got *Entry) []Mismatch {
+	var ms []Mismatch
+	check := func(field string, differ bool, w, g interface{}) {
+		if differ {
+			ms = append(ms, Mismatch{
+				Path:  want.Path,
+				Field: field,
+				Want:  fmt.Sprint(w),
+				Got:   fmt.Sprint(g),
+			})
+		}
+	}
+	check("type", want.Type != got.Type, want.Type, got.Type)
+	if want.Type != got.Type {
+		return ms
+	}
+	check("size", want.Size != got.Size, want.Size, got.Size)
+	check("mode", want.Mode != got.Mode, formatMode(want.Mode), formatMode(got.Mode))
+	check("uid", want.UID != got.UID, want.UID, got.UID)
+	check("gid", want.GID != got.GID, want.GID, got.GID)
+	check("mtime", !want.ModTime.Equal(got.ModTime),
+		want.ModTime.Format(time.RFC3339Nano), got.ModTime.Format(time.RFC3339Nano))
+	check("link", want.Link != got.Link, want.Link, got.Link)
+	if want.SHA256 != "" {
+		check("sha256", want.SHA256 != got.SHA256, want.SHA256, got.SHA256)
+	}
+	return ms
+}
+
+// formatMode returns the Unix octal notation of the permission and
+// special bits of m, e.g. "0755" or "4755".
+func formatMode(m os.FileMode) string {
+	bits := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+// parseMode is the inverse of formatMode.
+func parseMode(s string) (os.FileMode, error) {
+	var bits uint32
+	if _, err := fmt.Sscanf(s, "%o", &bits); err != nil || bits > 07777 {
+		return 0, fmt.Errorf("manifest: invalid mode %q", s)
+	}
+	m := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m, nil
+}
@@ -0,0 +1,110 @@
+package manifest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"path", "type", "size", "mode", "uid", "gid", "mtime", "link", "sha256"}
+
+type csvWriter struct {
+	cw          *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{cw: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Write(e *Entry) error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if err := w.cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	return w.cw.Write([]string{
+		e.Path,
+		string(e.Type),
+		strconv.FormatInt(e.Size, 10),
+		formatMode(e.Mode),
+		strconv.FormatUint(uint64(e.UID), 10),
+		strconv.FormatUint(uint64(e.GID), 10),
+		e.ModTime.Format(time.RFC3339Nano),
+		e.Link,
+		e.SHA256,
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if err := w.cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+type csvReader struct {
+	cr         *csv.Reader
+	readHeader bool
+	record     int // number of the last record read, counting the header
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	cr.ReuseRecord = true
+	return &csvReader{cr: cr}
+}
+
+func (r *csvReader) Read() (*Entry, error) {
+	if !r.readHeader {
+		r.readHeader = true
+		rec, err := r.cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		r.record++
+		for i, name := range csvHeader {
+			if rec[i] != name {
+				return nil, fmt.Errorf("manifest: unexpected CSV header %q", rec)
+			}
+		}
+	}
+	rec, err := r.cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.record++
+	e := &Entry{
+		Path:   rec[0],
+		Type:   Type(rec[1]),
+		Link:   rec[7],
+		SHA256: rec[8],
+	}
+	if e.Size, err = strconv.ParseInt(rec[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("manifest: record %d: invalid size: %v", r.record, err)
+	}
+	if e.Mode, err = parseMode(rec[3]); err != nil {
+		return nil, fmt.Errorf("manifest: record %d: %v", r.record, err)
+	}
+	uid, err := strconv.ParseUint(rec[4], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: record %d: invalid uid: %v", r.record, err)
+	}
+	gid, err := strconv.ParseUint(rec[5], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: record %d: invalid gid: %v", r.record, err)
+	}
+	e.UID, e.GID = uint32(uid), uint32(gid)
+	if e.ModTime, err = time.Parse(time.RFC3339Nano, rec[6]); err != nil {
+		return nil, fmt.Errorf("manifest: record %d: invalid mtime: %v", r.record, err)
+	}
+	return e, nil
+}
@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// jsonEntry is the JSON Lines encoding of an Entry.
+type jsonEntry struct {
+	Path    string    `json:"path"`
+	Type    Type      `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	UID     uint32    `json:"uid"`
+	GID     uint32    `json:"gid"`
+	ModTime time.Time `json:"mtime"`
+	Link    string    `json:"link,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+type jsonlWriter struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonlWriter{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+func (w *jsonlWriter) Write(e *Entry) error {
+	return w.enc.Encode(jsonEntry{
+		Path:    e.Path,
+		Type:    e.Type,
+		Size:    e.Size,
+		Mode:    formatMode(e.Mode),
+		UID:     e.UID,
+		GID:     e.GID,
+		ModTime: e.ModTime,
+		Link:    e.Link,
+		SHA256:  e.SHA256,
+	})
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.bw.Flush()
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return &jsonlReader{dec: dec}
+}
+
+func (r *jsonlReader) Read() (*Entry, error) {
+	var je jsonEntry
+	if err := r.dec.Decode(&je); err != nil {
+		return nil, err
+	}
+	mode, err := parseMode(je.Mode)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{
+		Path:    je.Path,
+		Type:    je.Type,
+		Size:    je.Size,
+		Mode:    mode,
+		UID:     je.UID,
+		GID:     je.GID,
+		ModTime: je.ModTime,
+		Link:    je.Link,
+		SHA256:  je.SHA256,
+	}, nil
+}
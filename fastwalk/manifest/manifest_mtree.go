@@ -0,0 +1,252 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mtreeWriter writes entries in the full-path form of mtree(5), one
+// line per entry with every keyword spelled out.
+type mtreeWriter struct {
+	bw          *bufio.Writer
+	wroteHeader bool
+}
+
+func newMtreeWriter(w io.Writer) *mtreeWriter {
+	return &mtreeWriter{bw: bufio.NewWriter(w)}
+}
+
+func (w *mtreeWriter) writeHeader() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.bw.WriteString("#mtree\n")
+	}
+}
+
+func (w *mtreeWriter) Write(e *Entry) error {
+	w.writeHeader()
+	path := "."
+	if e.Path != "." {
+		path = "./" + e.Path
+	}
+	fmt.Fprintf(w.bw, "%s type=%s", mtreeEscape(path), e.Type)
+	if e.Type == TypeFile {
+		fmt.Fprintf(w.bw, " size=%d", e.Size)
+	}
+	fmt.Fprintf(w.bw, " mode=%s uid=%d gid=%d time=%d.%09d",
+		formatMode(e.Mode), e.UID, e.GID, e.ModTime.Unix(), e.ModTime.Nanosecond())
+	if e.Link != "" {
+		fmt.Fprintf(w.bw, " link=%s", mtreeEscape(e.Link))
+	}
+	if e.SHA256 != "" {
+		fmt.Fprintf(w.bw, " sha256digest=%s", e.SHA256)
+	}
+	_, err := w.bw.WriteString("\n")
+	return err
+}
+
+func (w *mtreeWriter) Flush() error {
+	w.writeHeader()
+	return w.bw.Flush()
+}
+
+// mtreeEscape encodes s in the octal vis(3) style used by mtree(5), so
+// that it contains no whitespace, comment or escape characters.
+func mtreeEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' || c == '=' {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// mtreeUnescape is the inverse of mtreeEscape.
+func mtreeUnescape(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			c, _ := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			b.WriteByte(byte(c))
+			i += 3
+		} else if i+1 < len(s) && s[i+1] == '\\' {
+			b.WriteByte('\\')
+			i++
+		} else {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+	}
+	return b.String(), nil
+}
+
+func isOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+// mtreeReader reads the full-path form of mtree(5), including /set and
+// /unset defaults. Unknown keywords are ignored.
+type mtreeReader struct {
+	s    *bufio.Scanner
+	line int
+	set  map[string]string
+}
+
+func newMtreeReader(r io.Reader) *mtreeReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	return &mtreeReader{s: s, set: map[string]string{}}
+}
+
+// next returns the next logical line, joining lines ending in a
+// backslash.
+func (r *mtreeReader) next() (string, error) {
+	var line string
+	for r.s.Scan() {
+		r.line++
+		text := r.s.Text()
+		if strings.HasSuffix(text, "\\") {
+			line += text[:len(text)-1] + " "
+			continue
+		}
+		line += text
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			line = ""
+			continue
+		}
+		return trimmed, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (r *mtreeReader) Read() (*Entry, error) {
+	for {
+		line, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "/set":
+			for _, kv := range fields[1:] {
+				if i := strings.IndexByte(kv, '='); i >= 0 {
+					r.set[kv[:i]] = kv[i+1:]
+				}
+			}
+			continue
+		case "/unset":
+			for _, k := range fields[1:] {
+				if k == "all" {
+					r.set = map[string]string{}
+				}
+				delete(r.set, k)
+			}
+			continue
+		}
+		e, err := r.parse(fields)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: mtree line %d: %v", r.line, err)
+		}
+		return e, nil
+	}
+}
+
+func (r *mtreeReader) parse(fields []string) (*Entry, error) {
+	path, err := mtreeUnescape(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if path != "." && !strings.HasPrefix(path, "./") {
+		return nil, fmt.Errorf("entry %q is not in full-path form", path)
+	}
+	e := &Entry{Path: "."}
+	if path != "." {
+		e.Path = strings.TrimPrefix(path, "./")
+	}
+
+	kvs := make(map[string]string, len(r.set)+len(fields)-1)
+	for k, v := range r.set {
+		kvs[k] = v
+	}
+	for _, kv := range fields[1:] {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid keyword %q", kv)
+		}
+		kvs[kv[:i]] = kv[i+1:]
+	}
+
+	for k, v := range kvs {
+		switch k {
+		case "type":
+			e.Type = Type(v)
+		case "size":
+			e.Size, err = strconv.ParseInt(v, 10, 64)
+		case "mode":
+			e.Mode, err = parseMode(v)
+		case "uid":
+			var n uint64
+			n, err = strconv.ParseUint(v, 10, 32)
+			e.UID = uint32(n)
+		case "gid":
+			var n uint64
+			n, err = strconv.ParseUint(v, 10, 32)
+			e.GID = uint32(n)
+		case "time":
+			e.ModTime, err = parseMtreeTime(v)
+		case "link":
+			e.Link, err = mtreeUnescape(v)
+		case "sha256digest", "sha256":
+			e.SHA256 = v
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", k, err)
+		}
+	}
+	if e.Type == "" {
+		return nil, fmt.Errorf("entry %q has no type", path)
+	}
+	return e, nil
+}
+
+// parseMtreeTime parses the seconds.nanoseconds form of the time
+// keyword.
+func parseMtreeTime(s string) (time.Time, error) {
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			return time.Time{}, fmt.Errorf("fraction %q too long", frac)
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
@@ -0,0 +1,10 @@
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd
+
+package manifest
+
+import "os"
+
+// owner reports no owner where the platform has no Unix ownership.
+func owner(fi os.FileInfo) (uid, gid uint32) {
+	return 0, 0
+}
@@ -0,0 +1,181 @@
+package manifest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk/manifest"
+)
+
+func makeTree(t *testing.T) string {
+	tempdir, err := ioutil.TempDir("", "test-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a/one.txt":          "one",
+		"a/b/two.txt":        "two",
+		"with space #1=x.go": "weird name",
+	}
+	for path, contents := range files {
+		file := filepath.Join(tempdir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a/one.txt", filepath.Join(tempdir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return tempdir
+}
+
+func export(t *testing.T, root string, f manifest.Format) []byte {
+	var buf bytes.Buffer
+	w, err := manifest.NewWriter(&buf, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Export(root, w, &manifest.Options{Checksum: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func verify(t *testing.T, root string, f manifest.Format, data []byte) []string {
+	r, err := manifest.NewReader(bytes.NewReader(data), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = manifest.Verify(root, r, func(m manifest.Mismatch) error {
+		got = append(got, m.Path+" "+m.Field)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestRoundTrip(t *testing.T) {
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	for _, f := range []manifest.Format{manifest.JSONL, manifest.CSV, manifest.Mtree} {
+		data := export(t, root, f)
+
+		r, err := manifest.NewReader(bytes.NewReader(data), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := map[string]*manifest.Entry{}
+		for {
+			e, err := r.Read()
+			if err != nil {
+				break
+			}
+			entries[e.Path] = e
+		}
+		if len(entries) != 7 {
+			t.Errorf("%v: read %d entries, want 7:\n%s", f, len(entries), data)
+		}
+		if e := entries["with space #1=x.go"]; e == nil || e.Size != 10 || e.SHA256 == "" || e.Mode != 0644 {
+			t.Errorf("%v: bad entry for escaped name: %+v", f, e)
+		}
+		if e := entries["link"]; e == nil || e.Type != manifest.TypeLink || e.Link != "a/one.txt" {
+			t.Errorf("%v: bad entry for symlink: %+v", f, e)
+		}
+		if e := entries["."]; e == nil || e.Type != manifest.TypeDir {
+			t.Errorf("%v: bad entry for root: %+v", f, e)
+		}
+
+		if got := verify(t, root, f, data); len(got) != 0 {
+			t.Errorf("%v: unexpected mismatches: %q", f, got)
+		}
+	}
+}
+
+func TestVerify_Mismatches(t *testing.T) {
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	for _, f := range []manifest.Format{manifest.JSONL, manifest.CSV, manifest.Mtree} {
+		data := export(t, root, f)
+
+		// Same size, different contents and mtime.
+		mtime := time.Now().Add(-time.Hour)
+		if err := ioutil.WriteFile(filepath.Join(root, "a/one.txt"), []byte("ONE"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, "a/one.txt"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(root, "a/b/two.txt"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(root, "with space #1=x.go"), filepath.Join(root, "renamed")); err != nil {
+			t.Fatal(err)
+		}
+
+		got := verify(t, root, f, data)
+		want := []string{
+			"a/b/two.txt mode",
+			"a/one.txt mtime",
+			"a/one.txt sha256",
+			"renamed extra",
+			"with space #1=x.go missing",
+		}
+		// The root's mtime changes with the rename.
+		if len(got) > 0 && got[0] == ". mtime" {
+			got = got[1:]
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got mismatches %q, want %q", f, got, want)
+		}
+
+		os.RemoveAll(root)
+		root = makeTree(t)
+	}
+	os.RemoveAll(root)
+}
+
+func TestMtree_SetDefaults(t *testing.T) {
+	const input = `#mtree
+/set type=file uid=0 gid=0 mode=0644
+. type=dir mode=0755 time=1.5
+./a\040b size=3 time=2.000000001 \
+    sha256digest=abc
+/unset all
+./c type=link mode=0777 time=3 link=a\040b
+`
+	r, err := manifest.NewReader(bytes.NewReader([]byte(input)), manifest.Mtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []manifest.Entry
+	for {
+		e, err := r.Read()
+		if err != nil {
+			break
+		}
+		got = append(got, *e)
+	}
+	want := []manifest.Entry{
+		{Path: ".", Type: manifest.TypeDir, Mode: 0755, ModTime: time.Unix(1, 5e8)},
+		{Path: "a b", Type: manifest.TypeFile, Size: 3, Mode: 0644, ModTime: time.Unix(2, 1), SHA256: "abc"},
+		{Path: "c", Type: manifest.TypeLink, Mode: 0777, ModTime: time.Unix(3, 0), Link: "a b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,15 @@
+// +build linux darwin freebsd openbsd netbsd
+
+package manifest
+
+import (
+	"os"
+	"syscall"
+)
+
+func owner(fi os.FileInfo) (uid, gid uint32) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid
+	}
+	return 0, 0
+}
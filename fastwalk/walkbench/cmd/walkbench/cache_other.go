@@ -0,0 +1,12 @@
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func dropCaches() error {
+	return errors.New("cold mode is not supported on " + runtime.GOOS)
+}
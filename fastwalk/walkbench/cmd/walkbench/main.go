@@ -0,0 +1,261 @@
+// Command walkbench compares fastwalk, godirwalk and filepath.WalkDir
+// on a generated file tree and writes a JSON report.
+//
+// Usage:
+//
+//	walkbench [-depth n] [-fanout n] [-files n] [-symlinks ratio] [-seed n]
+//	          [-dir path] [-walkers list] [-modes warm,cold] [-count n]
+//	          [-syscalls] [-o file]
+//
+// Each walk runs in a child process, so allocations and system calls
+// are those of one walker alone. In warm mode, the default, the tree is
+// walked once beforehand. In cold mode the kernel's page, dentry and
+// inode caches are dropped before every walk, which requires root on
+// Linux.
+// With -syscalls, each walker is run once more under "strace -f -c" to
+// count its system calls.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shanebarnes/bits/fastwalk/walkbench"
+)
+
+type report struct {
+	GoVersion string               `json:"go_version"`
+	GOOS      string               `json:"goos"`
+	GOARCH    string               `json:"goarch"`
+	NumCPU    int                  `json:"num_cpu"`
+	Time      time.Time            `json:"time"`
+	Tree      *walkbench.TreeSpec  `json:"tree,omitempty"` // nil if -dir was given
+	Stats     *walkbench.TreeStats `json:"stats,omitempty"`
+	Entries   int64                `json:"entries"`
+	Rows      []row                `json:"results"`
+}
+
+type row struct {
+	Walker              string             `json:"walker"`
+	Mode                string             `json:"mode"`
+	Runs                []walkbench.Result `json:"runs"`
+	MedianEntriesPerSec float64            `json:"median_entries_per_sec"`
+	Syscalls            *int64             `json:"syscalls,omitempty"`
+}
+
+type options struct {
+	spec     walkbench.TreeSpec
+	dir      string
+	walkers  string
+	modes    string
+	count    int
+	syscalls bool
+	out      string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("walkbench: ")
+
+	var opts options
+	flag.IntVar(&opts.spec.Depth, "depth", 4, "levels of subdirectories below the root")
+	flag.IntVar(&opts.spec.Fanout, "fanout", 6, "subdirectories per directory")
+	flag.IntVar(&opts.spec.FilesPerDir, "files", 32, "files per directory")
+	flag.Float64Var(&opts.spec.SymlinkRatio, "symlinks", 0.1, "fraction of files that are symbolic links")
+	flag.Int64Var(&opts.spec.Seed, "seed", 1, "random seed for the placement of symbolic links")
+	flag.StringVar(&opts.dir, "dir", "", "walk this existing tree instead of generating one")
+	flag.StringVar(&opts.walkers, "walkers", "fastwalk,godirwalk,filepath.WalkDir", "comma-separated walkers to compare")
+	flag.StringVar(&opts.modes, "modes", "warm", "comma-separated cache modes: warm, cold (cold requires root on Linux)")
+	flag.IntVar(&opts.count, "count", 5, "number of measured walks per walker and mode")
+	flag.BoolVar(&opts.syscalls, "syscalls", false, "count system calls with strace")
+	flag.StringVar(&opts.out, "o", "", "write the report to `file` instead of standard output")
+	child := flag.String("child", "", "internal: measure one walk with the named walker and print the result")
+	flag.Parse()
+
+	if *child != "" {
+		runChild(*child, flag.Arg(0))
+		return
+	}
+	// run cleans up after itself before returning, which log.Fatal
+	// would not let it do.
+	if err := run(&opts); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+// run measures the walkers as opts say and writes the report.
+func run(opts *options) error {
+	if opts.count < 1 {
+		return errors.New("-count must be at least 1")
+	}
+	var ws []walkbench.Walker
+	for _, name := range strings.Split(opts.walkers, ",") {
+		w, err := walkbench.LookupWalker(name)
+		if err != nil {
+			return err
+		}
+		ws = append(ws, w)
+	}
+	modes := strings.Split(opts.modes, ",")
+	for _, mode := range modes {
+		if mode != "warm" && mode != "cold" {
+			return fmt.Errorf("unknown mode %q", mode)
+		}
+	}
+
+	rep := report{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Time:      time.Now().UTC(),
+	}
+	root := opts.dir
+	if root == "" {
+		tmp, err := ioutil.TempDir("", "walkbench")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		stats, err := walkbench.Generate(tmp, opts.spec)
+		if err != nil {
+			return err
+		}
+		root, rep.Tree, rep.Stats = tmp, &opts.spec, &stats
+		rep.Entries = stats.Entries()
+	}
+
+	for _, mode := range modes {
+		for _, w := range ws {
+			r, err := measure(w, mode, root, opts.count, opts.syscalls)
+			if err != nil {
+				return fmt.Errorf("%s, %s cache: %v", w.Name, mode, err)
+			}
+			if rep.Entries == 0 {
+				rep.Entries = r.Runs[0].Entries
+			}
+			for _, res := range r.Runs {
+				if res.Entries != rep.Entries {
+					return fmt.Errorf("%s saw %d entries, want %d", w.Name, res.Entries, rep.Entries)
+				}
+			}
+			rep.Rows = append(rep.Rows, r)
+		}
+	}
+
+	buf, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if opts.out == "" {
+		_, err = os.Stdout.Write(buf)
+		return err
+	}
+	return ioutil.WriteFile(opts.out, buf, 0644)
+}
+
+func runChild(name, root string) {
+	w, err := walkbench.LookupWalker(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r, err := walkbench.Measure(w, root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func measure(w walkbench.Walker, mode, root string, count int, syscalls bool) (row, error) {
+	r := row{Walker: w.Name, Mode: mode}
+	if mode == "warm" {
+		if _, err := w.Walk(root); err != nil {
+			return r, err
+		}
+	}
+	for i := 0; i < count; i++ {
+		if mode == "cold" {
+			if err := dropCaches(); err != nil {
+				return r, err
+			}
+		}
+		res, err := runWalk(w, root)
+		if err != nil {
+			return r, err
+		}
+		r.Runs = append(r.Runs, res)
+	}
+	rates := make([]float64, len(r.Runs))
+	for i, res := range r.Runs {
+		rates[i] = res.EntriesPerSec
+	}
+	sort.Float64s(rates)
+	if len(rates) > 0 {
+		r.MedianEntriesPerSec = rates[len(rates)/2]
+	}
+
+	if syscalls {
+		if mode == "cold" {
+			if err := dropCaches(); err != nil {
+				return r, err
+			}
+		}
+		n, err := countSyscalls(w, root)
+		if err != nil {
+			return r, err
+		}
+		r.Syscalls = &n
+	}
+	return r, nil
+}
+
+// runWalk measures one walk in a child process.
+func runWalk(w walkbench.Walker, root string) (walkbench.Result, error) {
+	var res walkbench.Result
+	cmd := exec.Command(os.Args[0], "-child", w.Name, root)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(out, &res)
+	return res, err
+}
+
+// countSyscalls runs one walk in a child process under strace and
+// returns the number of system calls it made. Setting up the process
+// accounts for some of them.
+func countSyscalls(w walkbench.Walker, root string) (int64, error) {
+	f, err := ioutil.TempFile("", "walkbench-strace")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cmd := exec.Command("strace", "-f", "-c", "-o", f.Name(), os.Args[0], "-child", w.Name, root)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return 0, errors.New("-syscalls requires strace")
+		}
+		return 0, fmt.Errorf("strace: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return walkbench.ParseStraceSummary(f)
+}
@@ -0,0 +1,10 @@
+module github.com/shanebarnes/bits/fastwalk/walkbench
+
+go 1.23
+
+require (
+	github.com/karrick/godirwalk v1.16.1
+	github.com/shanebarnes/bits/fastwalk v0.0.0
+)
+
+replace github.com/shanebarnes/bits/fastwalk => ../
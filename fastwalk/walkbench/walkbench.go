@@ -0,0 +1,241 @@
+// Package walkbench compares the speed of directory walkers on
+// generated file trees.
+//
+// Trees are described by a TreeSpec and built with Generate. Each
+// Walker counts the entries of a tree, and Measure reports how long it
+// took and how much it allocated. The cmd/walkbench command builds on
+// this package to run the walkers in separate processes with cold and
+// warm caches and to count their system calls.
+//
+// walkbench is a module of its own, so that fastwalk does not depend on
+// the walkers it is compared with.
+package walkbench
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/karrick/godirwalk"
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// TreeSpec describes a synthetic file tree. Every directory above
+// Depth has Fanout subdirectories and FilesPerDir other entries, of
+// which about SymlinkRatio are symbolic links to a sibling file and the
+// rest are empty regular files.
+type TreeSpec struct {
+	Depth        int     `json:"depth"`
+	Fanout       int     `json:"fanout"`
+	FilesPerDir  int     `json:"files_per_dir"`
+	SymlinkRatio float64 `json:"symlink_ratio"`
+	Seed         int64   `json:"seed"` // seeds the choice of symlinks
+}
+
+// TreeStats counts the entries of a generated tree, including its root.
+type TreeStats struct {
+	Dirs     int64 `json:"dirs"`
+	Files    int64 `json:"files"`
+	Symlinks int64 `json:"symlinks"`
+}
+
+// Entries returns the number of entries a walker should report.
+func (s TreeStats) Entries() int64 {
+	return s.Dirs + s.Files + s.Symlinks
+}
+
+// Generate creates the tree described by spec below dir, which must
+// exist and should be empty.
+func Generate(dir string, spec TreeSpec) (TreeStats, error) {
+	if spec.Depth < 0 || spec.Fanout < 0 || spec.FilesPerDir < 0 ||
+		spec.SymlinkRatio < 0 || spec.SymlinkRatio > 1 {
+		return TreeStats{}, fmt.Errorf("walkbench: invalid tree spec %+v", spec)
+	}
+	g := generator{spec: spec, rnd: rand.New(rand.NewSource(spec.Seed))}
+	g.stats.Dirs++ // dir itself
+	err := g.fill(dir, spec.Depth)
+	return g.stats, err
+}
+
+type generator struct {
+	spec  TreeSpec
+	rnd   *rand.Rand
+	stats TreeStats
+}
+
+func (g *generator) fill(dir string, depth int) error {
+	for i := 0; i < g.spec.FilesPerDir; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("f%04d", i))
+		// The first file is never a link, so links always have a
+		// target.
+		if i > 0 && g.rnd.Float64() < g.spec.SymlinkRatio {
+			if err := os.Symlink("f0000", name); err != nil {
+				return err
+			}
+			g.stats.Symlinks++
+			continue
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		g.stats.Files++
+	}
+	if depth == 0 {
+		return nil
+	}
+	for i := 0; i < g.spec.Fanout; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%04d", i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			return err
+		}
+		g.stats.Dirs++
+		if err := g.fill(sub, depth-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Walker walks the tree rooted at root without following symbolic
+// links and returns the number of entries seen, including root.
+type Walker struct {
+	Name string
+	Walk func(root string) (int64, error)
+}
+
+// Walkers returns the walkers compared by default.
+func Walkers() []Walker {
+	return []Walker{
+		{"fastwalk", walkFastwalk},
+		{"godirwalk", walkGodirwalk},
+		{"filepath.WalkDir", walkFilepath},
+	}
+}
+
+// LookupWalker returns the walker with the given name.
+func LookupWalker(name string) (Walker, error) {
+	for _, w := range Walkers() {
+		if w.Name == name {
+			return w, nil
+		}
+	}
+	return Walker{}, fmt.Errorf("walkbench: unknown walker %q", name)
+}
+
+func walkFastwalk(root string) (int64, error) {
+	var n int64
+	err := fastwalk.Walk(root, func(path string, typ os.FileMode) error {
+		atomic.AddInt64(&n, 1)
+		return nil
+	})
+	return n, err
+}
+
+func walkGodirwalk(root string) (int64, error) {
+	var n int64
+	err := godirwalk.Walk(root, &godirwalk.Options{
+		Unsorted: true,
+		Callback: func(path string, de *godirwalk.Dirent) error {
+			n++
+			return nil
+		},
+	})
+	return n, err
+}
+
+func walkFilepath(root string) (int64, error) {
+	var n int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Result is the outcome of one measured walk.
+type Result struct {
+	Entries       int64         `json:"entries"`
+	Duration      time.Duration `json:"duration_ns"`
+	EntriesPerSec float64       `json:"entries_per_sec"`
+	Mallocs       uint64        `json:"mallocs"`     // heap objects allocated
+	AllocBytes    uint64        `json:"alloc_bytes"` // heap bytes allocated
+}
+
+// Measure runs w on root once and reports its speed and allocations.
+// Allocations made by other goroutines during the walk are included.
+func Measure(w Walker, root string) (Result, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	n, err := w.Walk(root)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return Result{}, err
+	}
+	r := Result{
+		Entries:    n,
+		Duration:   elapsed,
+		Mallocs:    after.Mallocs - before.Mallocs,
+		AllocBytes: after.TotalAlloc - before.TotalAlloc,
+	}
+	if elapsed > 0 {
+		r.EntriesPerSec = float64(n) / elapsed.Seconds()
+	}
+	return r, nil
+}
+
+// ErrNoStraceTotal is returned by ParseStraceSummary when its input has
+// no calls column or no totals row.
+var ErrNoStraceTotal = errors.New("walkbench: no total in strace summary")
+
+// ParseStraceSummary returns the total number of system calls from the
+// summary table written by "strace -c".
+//
+// Which columns the table has, and which of them the totals row fills
+// in, depends on the version of strace, so the calls are found by
+// position: the values of each column end where its header does.
+func ParseStraceSummary(r io.Reader) (int64, error) {
+	sc := bufio.NewScanner(r)
+	end := -1 // end of the calls column
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if end < 0 {
+			if i := strings.Index(line, " calls"); i >= 0 && fields[0] == "%" {
+				end = i + len(" calls")
+			}
+			continue
+		}
+		if len(fields) == 0 || fields[len(fields)-1] != "total" || len(line) < end {
+			continue
+		}
+		calls := strings.Fields(line[:end])
+		if len(calls) == 0 {
+			break
+		}
+		return strconv.ParseInt(calls[len(calls)-1], 10, 64)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, ErrNoStraceTotal
+}
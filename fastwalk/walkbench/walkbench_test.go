@@ -0,0 +1,106 @@
+package walkbench_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shanebarnes/bits/fastwalk/walkbench"
+)
+
+var testSpec = walkbench.TreeSpec{Depth: 2, Fanout: 3, FilesPerDir: 10, SymlinkRatio: 0.3, Seed: 1}
+
+func generate(tb testing.TB, spec walkbench.TreeSpec) (string, walkbench.TreeStats) {
+	dir, err := ioutil.TempDir("", "test-walkbench")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stats, err := walkbench.Generate(dir, spec)
+	if err != nil {
+		os.RemoveAll(dir)
+		tb.Fatal(err)
+	}
+	return dir, stats
+}
+
+func TestGenerate(t *testing.T) {
+	dir, stats := generate(t, testSpec)
+	defer os.RemoveAll(dir)
+
+	if want := int64(1 + 3 + 9); stats.Dirs != want {
+		t.Errorf("Dirs = %d, want %d", stats.Dirs, want)
+	}
+	if got := stats.Files + stats.Symlinks; got != 13*10 {
+		t.Errorf("Files+Symlinks = %d, want %d", got, 13*10)
+	}
+	if stats.Symlinks == 0 {
+		t.Error("no symlinks generated")
+	}
+	for _, w := range walkbench.Walkers() {
+		n, err := w.Walk(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", w.Name, err)
+		}
+		if n != stats.Entries() {
+			t.Errorf("%s saw %d entries, want %d", w.Name, n, stats.Entries())
+		}
+	}
+}
+
+func TestParseStraceSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary string
+		want    int64
+	}{
+		{"current", `% time     seconds  usecs/call     calls    errors syscall
+------ ----------- ----------- --------- --------- ----------------
+ 41.29    0.000421           3       120           getdents64
+ 20.00    0.000204           2        88        12 openat
+------ ----------- ----------- --------- --------- ----------------
+100.00    0.001020           2       356        12 total
+`, 356},
+		{"current without errors", `% time     seconds  usecs/call     calls    errors syscall
+------ ----------- ----------- --------- --------- ----------------
+ 61.29    0.000625           5       120           getdents64
+------ ----------- ----------- --------- --------- ----------------
+100.00    0.001020           2       356           total
+`, 356},
+		{"old", `% time     seconds  usecs/call     calls    errors syscall
+------ ----------- ----------- --------- --------- ----------------
+ 41.29    0.000421           3       120           getdents64
+ 20.00    0.000204           2        88        12 openat
+------ ----------- ----------- --------- --------- ----------------
+100.00    0.001020                   356        12 total
+`, 356},
+	}
+	for _, tt := range tests {
+		n, err := walkbench.ParseStraceSummary(strings.NewReader(tt.summary))
+		if err != nil || n != tt.want {
+			t.Errorf("%s: ParseStraceSummary = %d, %v, want %d", tt.name, n, err, tt.want)
+		}
+	}
+	for _, summary := range []string{"", "100.00    0.001020           2       356        12 total\n"} {
+		if _, err := walkbench.ParseStraceSummary(strings.NewReader(summary)); err != walkbench.ErrNoStraceTotal {
+			t.Errorf("ParseStraceSummary(%q): err = %v, want %v", summary, err, walkbench.ErrNoStraceTotal)
+		}
+	}
+}
+
+func BenchmarkWalkers(b *testing.B) {
+	dir, stats := generate(b, walkbench.TreeSpec{Depth: 3, Fanout: 5, FilesPerDir: 20, SymlinkRatio: 0.1, Seed: 1})
+	defer os.RemoveAll(dir)
+
+	for _, w := range walkbench.Walkers() {
+		b.Run(w.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.Walk(dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(stats.Entries()*int64(b.N))/b.Elapsed().Seconds(), "entries/s")
+		})
+	}
+}
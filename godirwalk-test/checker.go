@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// lifetime is one period during which a path existed. The times bracket
+// the system calls that created and removed the path: it certainly
+// existed from created until removing, and possibly from creating until
+// removed.
+type lifetime struct {
+	creating, created time.Time
+	removing, removed time.Time // zero until the path is removed
+}
+
+// registry records the lifetimes of the paths created and removed
+// under the walked directory, so that walk results can be checked.
+type registry struct {
+	mu    sync.Mutex
+	paths map[string][]*lifetime
+}
+
+func newRegistry() *registry {
+	return &registry{paths: map[string][]*lifetime{}}
+}
+
+// create records the creation of path by fn.
+func (r *registry) create(path string, fn func() error) error {
+	l := &lifetime{creating: time.Now()}
+	r.mu.Lock()
+	r.paths[path] = append(r.paths[path], l)
+	r.mu.Unlock()
+
+	err := fn()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		ls := r.paths[path]
+		r.paths[path] = ls[:len(ls)-1]
+		return err
+	}
+	l.created = time.Now()
+	return nil
+}
+
+// remove records the removal of path by fn.
+func (r *registry) remove(path string, fn func() error) error {
+	r.mu.Lock()
+	ls := r.paths[path]
+	var l *lifetime
+	if len(ls) > 0 && ls[len(ls)-1].removing.IsZero() {
+		l = ls[len(ls)-1]
+		l.removing = time.Now()
+	}
+	r.mu.Unlock()
+
+	err := fn()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l != nil {
+		if err != nil {
+			l.removing = time.Time{}
+		} else {
+			l.removed = time.Now()
+		}
+	}
+	return err
+}
+
+// rename records the renaming of from to to by fn.
+func (r *registry) rename(from, to string, fn func() error) error {
+	now := time.Now()
+	r.mu.Lock()
+	var old *lifetime
+	if ls := r.paths[from]; len(ls) > 0 && ls[len(ls)-1].removing.IsZero() {
+		old = ls[len(ls)-1]
+		old.removing = now
+	}
+	l := &lifetime{creating: now}
+	r.paths[to] = append(r.paths[to], l)
+	r.mu.Unlock()
+
+	err := fn()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if old != nil {
+			old.removing = time.Time{}
+		}
+		ls := r.paths[to]
+		r.paths[to] = ls[:len(ls)-1]
+		return err
+	}
+	now = time.Now()
+	if old != nil {
+		old.removed = now
+	}
+	l.created = now
+	return nil
+}
+
+// walkReport is the outcome of checking one walk.
+type walkReport struct {
+	Seen       int      // distinct paths reported
+	Stable     int      // paths that existed for the whole walk
+	Missing    []string // stable paths not reported
+	Duplicates []string // stable paths reported more than once
+	Phantoms   []string // reported paths that never existed during the walk
+}
+
+func (w *walkReport) ok() bool {
+	return len(w.Missing) == 0 && len(w.Duplicates) == 0 && len(w.Phantoms) == 0
+}
+
+// check compares the paths reported by a walk that ran from start to
+// end, with the number of times each was reported, against the
+// recorded lifetimes.
+func (r *registry) check(start, end time.Time, seen map[string]int) *walkReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := &walkReport{Seen: len(seen)}
+	for path, ls := range r.paths {
+		for _, l := range ls {
+			stable := !l.created.IsZero() && l.created.Before(start) &&
+				(l.removing.IsZero() || l.removing.After(end))
+			if !stable {
+				continue
+			}
+			rep.Stable++
+			switch n := seen[path]; {
+			case n == 0:
+				rep.Missing = append(rep.Missing, path)
+			case n > 1:
+				rep.Duplicates = append(rep.Duplicates, path)
+			}
+			break
+		}
+	}
+	for path := range seen {
+		possible := false
+		for _, l := range r.paths[path] {
+			if l.creating.Before(end) && (l.removed.IsZero() || l.removed.After(start)) {
+				possible = true
+				break
+			}
+		}
+		if !possible {
+			rep.Phantoms = append(rep.Phantoms, path)
+		}
+	}
+	sort.Strings(rep.Missing)
+	sort.Strings(rep.Duplicates)
+	sort.Strings(rep.Phantoms)
+	return rep
+}
@@ -1,8 +1,11 @@
 module godirwalk-test
 
-go 1.14
+go 1.23
 
 require (
+	// The version 99bb374.patch applies to; keep them in step.
 	github.com/karrick/godirwalk v1.15.6
-	github.com/pkg/errors v0.9.1 // indirect
+	github.com/shanebarnes/bits/fastwalk v0.0.0
 )
+
+replace github.com/shanebarnes/bits/fastwalk => ../fastwalk
@@ -1,9 +1,24 @@
+// Command godirwalk-test checks that directory walkers report a
+// consistent view of a directory whose contents change during the walk.
+//
+// It creates sets*files files in a temporary directory, churns the
+// directory according to a workload profile, and walks the directory
+// repeatedly meanwhile. The default "bulk" profile removes all the
+// files after a while; the others create, rename and remove files and
+// nested directories at set rates for -duration. Rates of individual
+// operations can be overridden with the -rate-* flags, except with the
+// bulk profile; setting them all to zero leaves the tree alone for
+// -duration.
+// Every walk is checked against a record of when each file was created
+// and removed: each file that existed for the whole walk must be
+// reported exactly once, and no file may be reported that did not exist
+// at some point during the walk. The exit status is 1 if any walk fails
+// the check.
 package main
 
 import (
 	"flag"
 	"fmt"
-	"github.com/karrick/godirwalk"
 	"io/ioutil"
 	"os"
 	"runtime"
@@ -12,9 +27,17 @@ import (
 )
 
 var (
-	MAX_FILES int
-	MAX_SETS int
+	MAX_FILES   int
+	MAX_SETS    int
 	MAX_THREADS int
+	HOLD        time.Duration
+	DURATION    time.Duration
+	MAX_DEPTH   int
+	SEED        int64
+	PROFILE     profile
+	BULK        bool
+	WALKER      string
+	VERBOSE     bool
 )
 
 func handleErr(fn func() error) {
@@ -27,18 +50,64 @@ func handleErr(fn func() error) {
 func init() {
 	files := flag.Int("files", 100, "file count")
 	sets := flag.Int("sets", 3, "set count")
-	threads := flag.Int("threads", runtime.GOMAXPROCS(0), "thread count")
+	threads := flag.Int("threads", runtime.GOMAXPROCS(0), "thread count (fastwalk workers)")
+	hold := flag.Duration("hold", 10*time.Second, "time to keep the files before removing them")
+	profileName := flag.String("profile", "bulk", "churn workload: "+profileNames())
+	duration := flag.Duration("duration", 10*time.Second, "time to churn for, except with the bulk profile")
+	maxDepth := flag.Int("max-depth", 3, "depth of the deepest directory created by churn")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed for the churn")
+	rates := map[string]*float64{}
+	for _, op := range []string{"create", "rename", "delete", "mkdir", "rmdir"} {
+		rates[op] = flag.Float64("rate-"+op, -1, op+" operations per second (-1 for the profile's rate)")
+	}
+	walker := flag.String("walker", "all", "walker to check: godirwalk, fastwalk or all")
+	verbose := flag.Bool("v", false, "print the paths of failed checks")
 
 	flag.Parse()
 
+	prof, ok := profiles[*profileName]
+	if !ok {
+		fmt.Printf("unknown profile %q; want one of %s\n", *profileName, profileNames())
+		os.Exit(2)
+	}
+	bulk := *profileName == "bulk"
+	for op, field := range map[string]*float64{
+		"create": &prof.Create,
+		"rename": &prof.Rename,
+		"delete": &prof.Delete,
+		"mkdir":  &prof.Mkdir,
+		"rmdir":  &prof.Rmdir,
+	} {
+		if *rates[op] >= 0 {
+			if bulk {
+				fmt.Printf("-rate-%s does not apply to the bulk profile\n", op)
+				os.Exit(2)
+			}
+			*field = *rates[op]
+		}
+	}
+
 	MAX_SETS = *sets
 	MAX_FILES = *files
 	MAX_THREADS = *threads
+	HOLD = *hold
+	DURATION = *duration
+	MAX_DEPTH = *maxDepth
+	SEED = *seed
+	PROFILE = prof
+	BULK = bulk
+	WALKER = *walker
+	VERBOSE = *verbose
 }
 
 func main() {
-	var dirname string
+	ws, err := lookupWalkers(WALKER)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
 
+	var dirname string
 	handleErr(func() error {
 		var err error
 		dirname, err = ioutil.TempDir(os.TempDir(), "walker*.noindex")
@@ -46,137 +115,104 @@ func main() {
 		return err
 	})
 
-	//var files []string
-	var files sync.Map
+	var files []string
 	for i := 0; i < MAX_SETS; i++ {
-		//fmt.Println("Creating", MAX_FILES, "files in set", i+1)
-		//sub := fmt.Sprintf("set%d", i+1)
-		//os.Mkdir(dirname + string(os.PathSeparator) + sub, 0777)
-		//files.Store(dirname + string(os.PathSeparator) + sub, 0)
 		for j := 0; j < MAX_FILES; j++ {
-			handleErr(func() error {
-				name := fmt.Sprintf("%s%s%dk.set%d.file%d", dirname, string(os.PathSeparator), MAX_SETS*MAX_FILES/1000, i+1, j+1)
-				//tmpfile, err := os.Create(name)
-				files.Store(name, 0)
-				//files.Store(tmpfile.Name(), 0)
-				//files = append(files, tmpfile.Name())
-				//fmt.Println("Creating file:", tmpfile.Name())
-				//tmpfile.Close()
-				//return err
-				return nil
-			})
+			name := fmt.Sprintf("%s%s%dk.set%d.file%d", dirname, string(os.PathSeparator), MAX_SETS*MAX_FILES/1000, i+1, j+1)
+			files = append(files, name)
 		}
 	}
 
-	defer handleErr(func() error {
-		err := os.RemoveAll(dirname)
-		fmt.Println("Removed directory:", dirname)
-		return err
-	})
-
+	reg := newRegistry()
+	c := newChurner(dirname, MAX_DEPTH, reg, SEED)
+	churnDone := make(chan struct{})
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		files.Range(func (key, value interface{}) bool {
-			handleErr(func() error {
-				tmpfile, err := os.Create(key.(string))
-				defer tmpfile.Close()
-				return err
-			})
-			return true
-		})
-		fmt.Println("Created", MAX_SETS*MAX_FILES, "files")
-		time.Sleep(10*time.Second)
-		//for len(files) > 0 {
-		files.Range(func(key, value interface{}) bool {
-			//i := rand.Intn(len(files)) * 0
-			//i := len(files)-1
-			//i := len(files)/2
-			//fmt.Printf("Removing file #%d: %s\n", i+1, files[i])
+		defer wg.Done()
+		defer close(churnDone)
+		for _, name := range files {
 			handleErr(func() error {
-				//return os.Remove(files[i])
-				return os.Remove(key.(string))
+				return reg.create(name, func() error {
+					f, err := os.Create(name)
+					if err != nil {
+						return err
+					}
+					return f.Close()
+				})
 			})
-			//files = append(files[:i], files[i+1:]...)
-			files.Delete(key.(string))
-			//time.Sleep(1*time.Millisecond)
-			return true
-		})
-		wg.Done()
-	} ()
-
-	ch := make(chan string, MAX_THREADS)
-	for i := 0; i < MAX_THREADS; i++ {
-		var ok bool = true
-		var filename string
-		wg.Add(1)
-		go func() {
-			for ok {
-				select {
-					case filename, ok = <- ch:
-						if ok {
-							// Do something
-							//os.Remove(filename)
-							//if _, exists := files.Load(filename); !exists {
-							//	fmt.Println("Detected new file", filename)
-							//}
-							//if _, err := os.Lstat(filename); err != nil {
-							//	fmt.Println("Failed to stat file", filename, ":", err)
-							//}
-							//time.Sleep(10*time.Microsecond)
-						}
-				}
+			c.addFile(name)
+		}
+		fmt.Println("Created", len(files), "files")
+		if BULK {
+			time.Sleep(HOLD)
+			c.removeAll()
+		} else {
+			fmt.Printf("Churning for %v with %+v (seed %d)\n", DURATION, PROFILE, SEED)
+			stop := make(chan struct{})
+			time.AfterFunc(DURATION, func() { close(stop) })
+			c.run(PROFILE, stop)
+		}
+		fmt.Println("Churn:", c.summary())
+	}()
+
+	failed := false
+	for walk := 1; ; walk++ {
+		done := false
+		select {
+		case <-churnDone:
+			// One more round of walks sees the final state.
+			done = true
+		default:
+		}
+		for _, w := range ws {
+			if !check(w, walk, dirname, reg) {
+				failed = true
 			}
-			wg.Done()
-		}()
+		}
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	wg.Wait()
 
 	handleErr(func() error {
-		var err error
-		counter := int64(1)
-
-		//rmCount := 0
-		time.Sleep(time.Second)
-		for err == nil && counter > 0 {
-			errCbCount := 0
-			//errStatCount := 0
-			counter = 0
-			fmt.Println("Walking directory:", dirname)
-			var dup sync.Map
-			//err := filepath.Walk(dirname, func(osPathname string, info os.FileInfo, err error) error {
-			err = godirwalk.Walk(dirname, &godirwalk.Options{
-				Callback: func(osPathname string, de *godirwalk.Dirent) error {
-					if _, exists := dup.Load(osPathname); exists {
-						//fmt.Println("Duplicate entry in same walk cycle:", osPathname)
-					} else {
-						dup.Store(osPathname, 0)
-					}
-					if osPathname != dirname {
-						counter++
-						ch <- osPathname
-					}
-
-					return nil
-				},
-				ErrorCallback: func(str string, err error) godirwalk.ErrorAction {
-					//fmt.Println("!!! Walker ErrorCallback:", str, " ", err)
-					errCbCount++
-					return godirwalk.SkipNode
-				},
-				Unsorted: true,
-			})
-
-			if err != nil {
-				fmt.Println("!!!! Encountered error walking directory:", err)
-			}
-			fmt.Println("Found", counter, "files in directory", ", error callbacks: ", errCbCount)
-			if counter > 0 {
-				time.Sleep(10 * time.Millisecond)
-			}
-		}
-		close(ch)
+		err := os.RemoveAll(dirname)
+		fmt.Println("Removed directory:", dirname)
 		return err
 	})
+	if failed {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
 
-	wg.Wait()
+// check walks dirname with w, prints the result and reports whether the
+// walk was consistent.
+func check(w walker, walk int, dirname string, reg *registry) bool {
+	rep, skipped, err := walkAndCheck(w, dirname, MAX_THREADS, reg)
+	ok := err == nil && rep.ok()
+	status := "ok"
+	if !ok {
+		status = "FAIL"
+	}
+	fmt.Printf("%s walk %d: %d reported, %d stable, %d missing, %d duplicates, %d phantoms, %d skipped errors: %s\n",
+		w.name, walk, rep.Seen, rep.Stable, len(rep.Missing), len(rep.Duplicates), len(rep.Phantoms), skipped, status)
+	if err != nil {
+		fmt.Println("!!!! Encountered error walking directory:", err)
+	}
+	if VERBOSE {
+		for _, p := range rep.Missing {
+			fmt.Println("  missing:", p)
+		}
+		for _, p := range rep.Duplicates {
+			fmt.Println("  duplicate:", p)
+		}
+		for _, p := range rep.Phantoms {
+			fmt.Println("  phantom:", p)
+		}
+	}
+	return ok
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/karrick/godirwalk"
+	"github.com/shanebarnes/bits/fastwalk"
+)
+
+// A walker walks the tree rooted at root, calling fn for every path
+// below root. fn is safe for concurrent use. Errors that the walker
+// skipped past are counted in skipped; err is the error that ended the
+// walk, if any.
+type walker struct {
+	name string
+	walk func(root string, threads int, fn func(path string)) (skipped int, err error)
+}
+
+var walkers = []walker{
+	{"godirwalk", walkGodirwalk},
+	{"fastwalk", walkFastwalk},
+}
+
+func lookupWalkers(name string) ([]walker, error) {
+	if name == "all" {
+		return walkers, nil
+	}
+	for _, w := range walkers {
+		if w.name == name {
+			return []walker{w}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown walker %q", name)
+}
+
+func walkGodirwalk(root string, threads int, fn func(path string)) (int, error) {
+	skipped := 0
+	err := godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if osPathname != root {
+				fn(osPathname)
+			}
+			return nil
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			skipped++
+			return godirwalk.SkipNode
+		},
+		Unsorted: true,
+	})
+	return skipped, err
+}
+
+// walkFastwalk walks with fastwalk's own workers, fed from a
+// skipQueue so that directories removed before they are read are
+// skipped, as walkGodirwalk skips them, rather than ending the walk.
+func walkFastwalk(root string, threads int, fn func(path string)) (int, error) {
+	q := newSkipQueue(root)
+	err := fastwalk.WalkQueue(q, &fastwalk.Options{NumWorkers: threads}, func(dir fastwalk.WorkItem, e fastwalk.Entry) error {
+		if e.Path != root {
+			fn(e.Path)
+		}
+		return nil
+	})
+	if err == nil {
+		err = q.err
+	}
+	return q.skipped, err
+}
+
+// skipQueue is an in-process fastwalk.WorkQueue that counts and skips
+// directories that no longer exist, and ends the walk on any other
+// error.
+type skipQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	todo    []fastwalk.WorkItem
+	busy    int // directories obtained from Get and not yet Done
+	skipped int
+	err     error
+}
+
+func newSkipQueue(root string) *skipQueue {
+	q := &skipQueue{todo: []fastwalk.WorkItem{{Dir: root}}}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *skipQueue) Get() (fastwalk.WorkItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.todo) == 0 && q.busy > 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if q.err != nil || len(q.todo) == 0 {
+		return fastwalk.WorkItem{}, fastwalk.ErrQueueDone
+	}
+	it := q.todo[len(q.todo)-1]
+	q.todo = q.todo[:len(q.todo)-1]
+	q.busy++
+	return it, nil
+}
+
+func (q *skipQueue) Put(parent, it fastwalk.WorkItem) error {
+	q.mu.Lock()
+	q.todo = append(q.todo, it)
+	q.mu.Unlock()
+	q.cond.Signal()
+	return nil
+}
+
+func (q *skipQueue) Done(it fastwalk.WorkItem, err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.busy--
+	if os.IsNotExist(err) {
+		q.skipped++
+	} else if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.cond.Broadcast()
+	return nil
+}
+
+// seenSet counts how often each path is reported during a walk.
+type seenSet struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func (s *seenSet) add(path string) {
+	s.mu.Lock()
+	s.count[path]++
+	s.mu.Unlock()
+}
+
+// walkAndCheck walks root with w and checks the paths reported against
+// reg.
+func walkAndCheck(w walker, root string, threads int, reg *registry) (*walkReport, int, error) {
+	seen := &seenSet{count: map[string]int{}}
+	start := time.Now()
+	skipped, err := w.walk(root, threads, seen.add)
+	end := time.Now()
+	return reg.check(start, end, seen.count), skipped, err
+}
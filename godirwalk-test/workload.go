@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A profile is a churn workload: the rates, in operations per second,
+// at which the contents of the walked directory are changed.
+type profile struct {
+	Create float64 // create a file in a random directory
+	Rename float64 // move a random file to another directory
+	Delete float64 // remove a random file
+	Mkdir  float64 // create a directory below a random directory
+	Rmdir  float64 // remove a random directory and everything below it
+}
+
+// profiles are the predefined workloads. The "bulk" workload is
+// special: it keeps the initial files for -hold and then removes them
+// all as fast as possible.
+var profiles = map[string]profile{
+	"bulk":   {},
+	"create": {Create: 1000},
+	"delete": {Delete: 1000},
+	"rename": {Rename: 1000},
+	"dirs":   {Create: 500, Mkdir: 100, Rmdir: 50},
+	"mixed":  {Create: 500, Rename: 500, Delete: 400, Mkdir: 50, Rmdir: 20},
+}
+
+func profileNames() string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// churner changes the tree rooted at root at the rates of a profile,
+// recording every change in a registry. Operations are applied one at
+// a time.
+type churner struct {
+	root     string
+	maxDepth int
+	reg      *registry
+	rnd      *rand.Rand
+
+	mu    sync.Mutex
+	files []string       // live files
+	dirs  []string       // live directories below root
+	depth map[string]int // depth of each directory; root is 0
+	next  int            // suffix of the next name created
+	ops   map[string]int // completed operations by kind
+	errs  map[string]int // failed operations by kind
+}
+
+func newChurner(root string, maxDepth int, reg *registry, seed int64) *churner {
+	return &churner{
+		root:     root,
+		maxDepth: maxDepth,
+		reg:      reg,
+		rnd:      rand.New(rand.NewSource(seed)),
+		depth:    map[string]int{root: 0},
+		ops:      map[string]int{},
+		errs:     map[string]int{},
+	}
+}
+
+// addFile records an initial file created under root.
+func (c *churner) addFile(path string) {
+	c.mu.Lock()
+	c.files = append(c.files, path)
+	c.mu.Unlock()
+}
+
+// run applies the workload p until stop is closed.
+func (c *churner) run(p profile, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, op := range []struct {
+		name string
+		rate float64
+		fn   func() error
+	}{
+		{"create", p.Create, c.create},
+		{"rename", p.Rename, c.rename},
+		{"delete", p.Delete, c.delete},
+		{"mkdir", p.Mkdir, c.mkdir},
+		{"rmdir", p.Rmdir, c.rmdir},
+	} {
+		if op.rate <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / op.rate))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+				c.mu.Lock()
+				if err := op.fn(); err != nil {
+					c.errs[op.name]++
+				} else {
+					c.ops[op.name]++
+				}
+				c.mu.Unlock()
+			}
+		}()
+	}
+	<-stop
+	wg.Wait()
+}
+
+// removeAll removes every file, as the bulk workload does.
+func (c *churner) removeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.files) > 0 {
+		if err := c.delete(); err != nil {
+			c.errs["delete"]++
+		} else {
+			c.ops["delete"]++
+		}
+	}
+}
+
+func (c *churner) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var parts []string
+	for _, name := range []string{"create", "rename", "delete", "mkdir", "rmdir"} {
+		if c.ops[name] > 0 || c.errs[name] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s (%d failed)", c.ops[name], name, c.errs[name]))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The operations below are called with c.mu held. An operation with
+// nothing to act on, such as a delete with no files left, does nothing.
+
+func (c *churner) newName(dir, prefix string) string {
+	c.next++
+	return filepath.Join(dir, fmt.Sprintf("%s%d", prefix, c.next))
+}
+
+// randomDir returns root or a random directory below it.
+func (c *churner) randomDir() string {
+	i := c.rnd.Intn(len(c.dirs) + 1)
+	if i == len(c.dirs) {
+		return c.root
+	}
+	return c.dirs[i]
+}
+
+func (c *churner) create() error {
+	name := c.newName(c.randomDir(), "churn.file")
+	err := c.reg.create(name, func() error {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	})
+	if err == nil {
+		c.files = append(c.files, name)
+	}
+	return err
+}
+
+func (c *churner) delete() error {
+	if len(c.files) == 0 {
+		return nil
+	}
+	i := c.rnd.Intn(len(c.files))
+	name := c.files[i]
+	if err := c.reg.remove(name, func() error { return os.Remove(name) }); err != nil {
+		return err
+	}
+	c.files = removeIndex(c.files, i)
+	return nil
+}
+
+func (c *churner) rename() error {
+	if len(c.files) == 0 {
+		return nil
+	}
+	i := c.rnd.Intn(len(c.files))
+	from := c.files[i]
+	to := c.newName(c.randomDir(), "churn.moved")
+	if err := c.reg.rename(from, to, func() error { return os.Rename(from, to) }); err != nil {
+		return err
+	}
+	c.files[i] = to
+	return nil
+}
+
+func (c *churner) mkdir() error {
+	var parents []string
+	for _, d := range append([]string{c.root}, c.dirs...) {
+		if c.depth[d] < c.maxDepth {
+			parents = append(parents, d)
+		}
+	}
+	if len(parents) == 0 {
+		return nil
+	}
+	parent := parents[c.rnd.Intn(len(parents))]
+	name := c.newName(parent, "churn.dir")
+	if err := c.reg.create(name, func() error { return os.Mkdir(name, 0777) }); err != nil {
+		return err
+	}
+	c.dirs = append(c.dirs, name)
+	c.depth[name] = c.depth[parent] + 1
+	return nil
+}
+
+// rmdir removes a random directory below root, deepest entries first.
+func (c *churner) rmdir() error {
+	if len(c.dirs) == 0 {
+		return nil
+	}
+	top := c.dirs[c.rnd.Intn(len(c.dirs))]
+	prefix := top + string(os.PathSeparator)
+	for i := 0; i < len(c.files); {
+		if name := c.files[i]; strings.HasPrefix(name, prefix) {
+			if err := c.reg.remove(name, func() error { return os.Remove(name) }); err != nil {
+				return err
+			}
+			c.files = removeIndex(c.files, i)
+			continue
+		}
+		i++
+	}
+	var doomed []string
+	for _, d := range c.dirs {
+		if d == top || strings.HasPrefix(d, prefix) {
+			doomed = append(doomed, d)
+		}
+	}
+	// Children sort after their parents.
+	sort.Sort(sort.Reverse(sort.StringSlice(doomed)))
+	for _, d := range doomed {
+		if err := c.reg.remove(d, func() error { return os.Remove(d) }); err != nil {
+			return err
+		}
+		for i, live := range c.dirs {
+			if live == d {
+				c.dirs = removeIndex(c.dirs, i)
+				break
+			}
+		}
+		delete(c.depth, d)
+	}
+	return nil
+}
+
+// removeIndex removes s[i], not preserving order.
+func removeIndex(s []string, i int) []string {
+	s[i] = s[len(s)-1]
+	return s[:len(s)-1]
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Error classes, as reported. ENOENT is usually a race: the path was
+// removed between being listed and being stat'ed or opened.
+const (
+	classNotExist = "enoent"
+	classAccess   = "eacces"
+	classLoop     = "eloop"
+	classIO       = "eio"
+	classOther    = "other"
+)
+
+var errorClasses = []string{classNotExist, classAccess, classLoop, classIO, classOther}
+
+func classify(err error) string {
+	switch {
+	case os.IsNotExist(err) || errors.Is(err, syscall.ENOENT):
+		return classNotExist
+	case os.IsPermission(err) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM):
+		return classAccess
+	case errors.Is(err, syscall.ELOOP):
+		return classLoop
+	case errors.Is(err, syscall.EIO):
+		return classIO
+	}
+	return classOther
+}
+
+// errorCount counts the errors of one class, keeping the first few as
+// samples.
+type errorCount struct {
+	Count   int64    `json:"count"`
+	Samples []string `json:"samples,omitempty"` // error messages, including the path
+}
+
+// errorCounts are the errors of a root, or of all roots, by class.
+type errorCounts map[string]*errorCount
+
+// add records err from op on path, keeping at most maxSamples samples
+// per class.
+func (ec errorCounts) add(op, path string, err error, maxSamples int) {
+	class := classify(err)
+	c := ec[class]
+	if c == nil {
+		c = &errorCount{}
+		ec[class] = c
+	}
+	c.Count++
+	if len(c.Samples) < maxSamples {
+		msg := err.Error()
+		if !strings.Contains(msg, path) {
+			msg = path + ": " + msg
+		}
+		c.Samples = append(c.Samples, op+": "+msg)
+	}
+}
+
+// merge adds the counts of other to ec. Samples are kept up to
+// maxSamples per class.
+func (ec errorCounts) merge(other errorCounts, maxSamples int) {
+	for class, o := range other {
+		c := ec[class]
+		if c == nil {
+			c = &errorCount{}
+			ec[class] = c
+		}
+		c.Count += o.Count
+		for _, s := range o.Samples {
+			if len(c.Samples) < maxSamples {
+				c.Samples = append(c.Samples, s)
+			}
+		}
+	}
+}
+
+func (ec errorCounts) copy() errorCounts {
+	c := make(errorCounts, len(ec))
+	for class, e := range ec {
+		ce := *e
+		ce.Samples = append([]string(nil), e.Samples...)
+		c[class] = &ce
+	}
+	return c
+}
+
+// total returns the number of errors of all classes.
+func (ec errorCounts) total() int64 {
+	var n int64
+	for _, c := range ec {
+		n += c.Count
+	}
+	return n
+}
+
+// classes returns the classes with errors, in the order of
+// errorClasses.
+func (ec errorCounts) classes() []string {
+	var cs []string
+	for _, class := range errorClasses {
+		if ec[class] != nil {
+			cs = append(cs, class)
+		}
+	}
+	return cs
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"math"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// total counts files and their bytes.
+type total struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (t *total) add(size int64) {
+	t.Files++
+	t.Bytes += size
+}
+
+// ageBounds are the upper bounds of the age buckets. The last bucket
+// holds everything older.
+var ageBounds = []struct {
+	label string
+	age   time.Duration
+}{
+	{"< 1 day", 24 * time.Hour},
+	{"< 1 week", 7 * 24 * time.Hour},
+	{"< 30 days", 30 * 24 * time.Hour},
+	{"< 90 days", 90 * 24 * time.Hour},
+	{"< 1 year", 365 * 24 * time.Hour},
+	{"< 2 years", 2 * 365 * 24 * time.Hour},
+	{"< 5 years", 5 * 365 * 24 * time.Hour},
+}
+
+const numAgeBuckets = 8 // len(ageBounds) + 1
+
+func ageBucket(age time.Duration) int {
+	for i, b := range ageBounds {
+		if age < b.age {
+			return i
+		}
+	}
+	return len(ageBounds)
+}
+
+func ageLabel(i int) string {
+	if i < len(ageBounds) {
+		return ageBounds[i].label
+	}
+	return ">= 5 years"
+}
+
+// distribution breaks down the files found by size, age, extension and
+// owner. Directories are not included. Each stat worker fills its own
+// distribution; they are merged once the walk is done.
+type distribution struct {
+	now    time.Time // ages are relative to now
+	sizes  [64]total // bucket 0 holds empty files, bucket i sizes in [2^(i-1), 2^i)
+	mtimes [numAgeBuckets]total
+	atimes [numAgeBuckets]total // only for files whose atime is known
+	exts   map[string]*total
+	owners map[uint32]*total // only for files whose owner is known
+}
+
+func newDistribution(now time.Time) *distribution {
+	return &distribution{
+		now:    now,
+		exts:   map[string]*total{},
+		owners: map[uint32]*total{},
+	}
+}
+
+func sizeBucket(size int64) int {
+	b := 0
+	for size > 0 {
+		b++
+		size >>= 1
+	}
+	return b
+}
+
+// extension returns the lower-cased extension of the base name of
+// path, ignoring the leading dot of hidden files, or "" if it has none.
+func extension(path string) string {
+	return strings.ToLower(filepath.Ext(strings.TrimPrefix(filepath.Base(path), ".")))
+}
+
+func (d *distribution) add(path string, fi os.FileInfo) {
+	if fi.IsDir() {
+		return
+	}
+	size := fi.Size()
+	d.sizes[sizeBucket(size)].add(size)
+	d.mtimes[ageBucket(d.now.Sub(fi.ModTime()))].add(size)
+	uid, atime, ok := ownerAndAtime(fi)
+	if ok {
+		d.atimes[ageBucket(d.now.Sub(atime))].add(size)
+		t := d.owners[uid]
+		if t == nil {
+			t = &total{}
+			d.owners[uid] = t
+		}
+		t.add(size)
+	}
+	ext := extension(path)
+	t := d.exts[ext]
+	if t == nil {
+		t = &total{}
+		d.exts[ext] = t
+	}
+	t.add(size)
+}
+
+// merge adds the counts of e to d.
+func (d *distribution) merge(e *distribution) {
+	for i := range d.sizes {
+		d.sizes[i].Files += e.sizes[i].Files
+		d.sizes[i].Bytes += e.sizes[i].Bytes
+	}
+	for i := range d.mtimes {
+		d.mtimes[i].Files += e.mtimes[i].Files
+		d.mtimes[i].Bytes += e.mtimes[i].Bytes
+		d.atimes[i].Files += e.atimes[i].Files
+		d.atimes[i].Bytes += e.atimes[i].Bytes
+	}
+	for ext, t := range e.exts {
+		if dt := d.exts[ext]; dt != nil {
+			dt.Files += t.Files
+			dt.Bytes += t.Bytes
+		} else {
+			c := *t
+			d.exts[ext] = &c
+		}
+	}
+	for uid, t := range e.owners {
+		if dt := d.owners[uid]; dt != nil {
+			dt.Files += t.Files
+			dt.Bytes += t.Bytes
+		} else {
+			c := *t
+			d.owners[uid] = &c
+		}
+	}
+}
+
+// The types below are the report of a distribution, as printed in
+// tables and encoded as JSON.
+
+type distReport struct {
+	Sizes      []sizeBucketReport `json:"sizes"`
+	Ages       []ageBucketReport  `json:"ages"`
+	Extensions []namedTotal       `json:"extensions"`
+	Owners     []namedTotal       `json:"owners"`
+}
+
+type sizeBucketReport struct {
+	Min int64 `json:"min"` // inclusive
+	Max int64 `json:"max"` // exclusive
+	total
+}
+
+type ageBucketReport struct {
+	Label string `json:"label"`
+	Mtime total  `json:"mtime"`
+	Atime total  `json:"atime"`
+}
+
+type namedTotal struct {
+	Name string `json:"name"`
+	total
+}
+
+// report returns the non-empty buckets of d. Extensions and owners are
+// sorted by bytes, largest first.
+func (d *distribution) report() *distReport {
+	r := &distReport{}
+	for i, t := range d.sizes {
+		if t.Files == 0 {
+			continue
+		}
+		b := sizeBucketReport{total: t}
+		if i > 0 {
+			b.Min = 1 << uint(i-1)
+			if i < 63 {
+				b.Max = 1 << uint(i)
+			} else {
+				b.Max = math.MaxInt64 // 1<<63 overflows
+			}
+		} else {
+			b.Max = 1
+		}
+		r.Sizes = append(r.Sizes, b)
+	}
+	for i := range d.mtimes {
+		if d.mtimes[i].Files > 0 || d.atimes[i].Files > 0 {
+			r.Ages = append(r.Ages, ageBucketReport{Label: ageLabel(i), Mtime: d.mtimes[i], Atime: d.atimes[i]})
+		}
+	}
+	for ext, t := range d.exts {
+		if ext == "" {
+			ext = "(none)"
+		}
+		r.Extensions = append(r.Extensions, namedTotal{Name: ext, total: *t})
+	}
+	names := map[uint32]string{}
+	for uid, t := range d.owners {
+		name, ok := names[uid]
+		if !ok {
+			name = strconv.FormatUint(uint64(uid), 10)
+			if u, err := user.LookupId(name); err == nil {
+				name = u.Username
+			}
+			names[uid] = name
+		}
+		r.Owners = append(r.Owners, namedTotal{Name: name, total: *t})
+	}
+	sortTotals(r.Extensions)
+	sortTotals(r.Owners)
+	return r
+}
+
+func sortTotals(ts []namedTotal) {
+	sort.Slice(ts, func(i, j int) bool {
+		if ts[i].Bytes != ts[j].Bytes {
+			return ts[i].Bytes > ts[j].Bytes
+		}
+		return ts[i].Name < ts[j].Name
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDistribution_SizeBuckets(t *testing.T) {
+	d := newDistribution(time.Now())
+	for _, size := range []int64{0, 1, 3, 1 << 62, math.MaxInt64} {
+		d.sizes[sizeBucket(size)].Files++
+	}
+	want := []struct{ min, max int64 }{
+		{0, 1},
+		{1, 2},
+		{2, 4},
+		{1 << 62, math.MaxInt64},
+	}
+	got := d.report().Sizes
+	if len(got) != len(want) {
+		t.Fatalf("got %d buckets, want %d: %+v", len(got), len(want), got)
+	}
+	for i, b := range got {
+		if b.Min != want[i].min || b.Max != want[i].max {
+			t.Errorf("bucket %d = [%d, %d), want [%d, %d)", i, b.Min, b.Max, want[i].min, want[i].max)
+		}
+	}
+	if got[3].Files != 2 {
+		t.Errorf("last bucket has %d files, want 2", got[3].Files)
+	}
+}
@@ -1,31 +1,46 @@
 package main
 
 import (
+	"bufio"
 	"flag"
-	"fmt"
-	"github.com/karrick/godirwalk"
-	"github.com/dustin/go-humanize"
 	"os"
 	"runtime"
-	"strconv"
-	"sync"
+	"strings"
 	"time"
 )
 
-var (
-	_byteCount int64
-	_fileCount int64
-	_mtx sync.Mutex
-)
+// dirList collects the values of a repeated flag.
+type dirList []string
+
+func (d *dirList) String() string     { return strings.Join(*d, ",") }
+func (d *dirList) Set(s string) error { *d = append(*d, s); return nil }
 
 func main() {
-	dir := flag.String("dir", "", "directory to walk")
+	var dirs dirList
+	flag.Var(&dirs, "dir", "directory to walk (may be repeated)")
+	rootsFile := flag.String("roots", "", "file listing directories to walk, one per line")
 	reportInt := flag.Int("report", 15, "report interval in seconds")
 	noStat := flag.Bool("nostat", false, "disable full stat on each file discovered")
 	workers := flag.Int("threads", runtime.NumCPU(), "number of threads")
+	format := flag.String("format", "text", "output format: text or json (one record per line)")
+	keepGoing := flag.Bool("continue", false, "continue past directories that cannot be read instead of stopping the walk of their root")
+	maxSamples := flag.Int("error-samples", 5, "number of paths kept as samples of each class of error")
+	top := flag.Int("top", 20, "number of extensions and owners listed in the text summary")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address while walking")
 	flag.Parse()
 
-	if *reportInt < 1 {
+	dirs = append(dirs, flag.Args()...)
+	if *rootsFile != "" {
+		listed, err := readRoots(*rootsFile)
+		if err != nil {
+			panic(err)
+		}
+		dirs = append(dirs, listed...)
+	}
+
+	if len(dirs) == 0 {
+		panic("At least one directory must be given")
+	} else if *reportInt < 1 {
 		panic("Report interval must be greater than zero")
 	} else if *workers < 1 {
 		panic("Threads must be greater than zero")
@@ -33,61 +48,62 @@ func main() {
 		panic("Threads must be less than or equal to 256")
 	}
 	reportDur := time.Duration(*reportInt) * time.Second
+	out, err := newPrinter(*format, os.Stdout, *top)
+	if err != nil {
+		panic(err)
+	}
 
-	fmt.Println("Starting walk of", *dir, "with", strconv.FormatInt(int64(*workers), 10), "threads and full stat mode =", !(*noStat))
+	out.start(dirs, *workers, !*noStat)
 	t0 := time.Now()
-	tr := t0
 
-	var wg sync.WaitGroup
-	wg.Add(*workers)
-	ch := make(chan string, *workers)
-	for i := 0; i < *workers; i++ {
-		go stat((!*noStat), &ch, &wg)
+	sc := newScanner(dirs, *workers, !*noStat, *keepGoing, *maxSamples, t0)
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr, &metrics{scanner: sc}); err != nil {
+			panic(err)
+		}
 	}
 
-	err := godirwalk.Walk(*dir, &godirwalk.Options{
-		Callback: func(osPathname string, de *godirwalk.Dirent) error {
-			ch <- osPathname
-			lastReport := time.Since(tr)
-			if lastReport > reportDur {
-				tr = tr.Add(reportDur)
-				_mtx.Lock()
-				fileCount := _fileCount
-				_mtx.Unlock()
-				fmt.Println("Found", fileCount, "files in", time.Since(t0))
+	stopReport := make(chan struct{})
+	reportDone := make(chan struct{})
+	go func() {
+		defer close(reportDone)
+		ticker := time.NewTicker(reportDur)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopReport:
+				return
+			case now := <-ticker.C:
+				snaps := snapshots(sc.roots, now)
+				out.progress(snaps, combine(snaps, t0, now, *maxSamples))
 			}
-			return nil
-		},
-		Unsorted: true, // (optional) set true for faster yet non-deterministic enumeration (see godoc)
-	})
+		}
+	}()
 
-	close(ch)
-	wg.Wait()
-	t1 := time.Now()
-	fmt.Println("Found a total of", _fileCount, "files,", humanize.Bytes(uint64(_byteCount)), "in", t1.Sub(t0))
-	fmt.Println("Completed walk of", *dir, ", err: ", err)
-}
+	sc.run()
+	close(stopReport)
+	<-reportDone
 
-func stat(fullStat bool, ch *chan string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for path := range *ch {
-		fileValid := true
-		fileSize := int64(0)
-		if fullStat {
-			if fi, err := os.Lstat(path); err == nil {
-				if !fi.IsDir() {
-					fileSize = fi.Size()
-				}
-			} else {
-				fileValid = false
-			}
-		}
+	now := time.Now()
+	snaps := snapshots(sc.roots, now)
+	out.summary(snaps, combine(snaps, t0, now, *maxSamples), sc.distribution())
+}
 
-		if !fullStat || fileValid {
-			_mtx.Lock()
-			_byteCount += fileSize
-			_fileCount += 1
-			_mtx.Unlock()
+// readRoots returns the directories listed in the named file, one per
+// line. Blank lines and lines starting with # are ignored.
+func readRoots(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var roots []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			roots = append(roots, line)
 		}
 	}
+	return roots, sc.Err()
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metrics serves the progress of a walk in the Prometheus text
+// exposition format.
+type metrics struct {
+	scanner *scanner
+}
+
+// serveMetrics serves m at /metrics on addr in the background.
+func serveMetrics(addr string, m *metrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w, time.Now())
+}
+
+func (m *metrics) write(w io.Writer, now time.Time) {
+	snaps := snapshots(m.scanner.roots, now)
+
+	perRoot := func(name, typ, help string, value func(s *snapshot) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for i := range snaps {
+			fmt.Fprintf(w, "%s{root=\"%s\"} %s\n", name, escapeLabel(snaps[i].Root), strconv.FormatFloat(value(&snaps[i]), 'f', -1, 64))
+		}
+	}
+	perRoot("godirwalk_files_total", "counter", "Files found and counted.",
+		func(s *snapshot) float64 { return float64(s.Files) })
+	perRoot("godirwalk_bytes_total", "counter", "Bytes in the files found.",
+		func(s *snapshot) float64 { return float64(s.Bytes) })
+	perRoot("godirwalk_stat_errors_total", "counter", "Paths that could not be stat'ed.",
+		func(s *snapshot) float64 { return float64(s.StatErrors) })
+	fmt.Fprintf(w, "# HELP godirwalk_errors_total Stat and walk errors by class.\n# TYPE godirwalk_errors_total counter\n")
+	for i := range snaps {
+		for _, class := range errorClasses {
+			var n int64
+			if c := snaps[i].Errors[class]; c != nil {
+				n = c.Count
+			}
+			fmt.Fprintf(w, "godirwalk_errors_total{root=\"%s\",class=\"%s\"} %d\n", escapeLabel(snaps[i].Root), class, n)
+		}
+	}
+	perRoot("godirwalk_files_per_second", "gauge", "Average rate at which files were found.",
+		func(s *snapshot) float64 { return s.filesPerSec() })
+	perRoot("godirwalk_walk_done", "gauge", "Whether the walk has finished (1) or not (0).",
+		func(s *snapshot) float64 {
+			if s.Done {
+				return 1
+			}
+			return 0
+		})
+
+	fmt.Fprintf(w, "# HELP godirwalk_active_workers Stat workers handling a path.\n# TYPE godirwalk_active_workers gauge\n")
+	fmt.Fprintf(w, "godirwalk_active_workers %d\n", m.scanner.active())
+	fmt.Fprintf(w, "# HELP godirwalk_workers Stat workers started.\n# TYPE godirwalk_workers gauge\n")
+	fmt.Fprintf(w, "godirwalk_workers %d\n", m.scanner.workers)
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
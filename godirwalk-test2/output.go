@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer writes the progress and results of a walk.
+type printer interface {
+	start(dirs []string, threads int, fullStat bool)
+	progress(snaps []snapshot, total snapshot)
+	// summary prints the final totals. dist is nil unless files were
+	// stat'ed.
+	summary(snaps []snapshot, total snapshot, dist *distReport)
+}
+
+// newPrinter returns a printer for format. Text summaries list the top
+// extensions and owners only.
+func newPrinter(format string, w io.Writer, top int) (printer, error) {
+	switch format {
+	case "text":
+		return textPrinter{w, top}, nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return jsonPrinter{enc}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type textPrinter struct {
+	w   io.Writer
+	top int
+}
+
+func (p textPrinter) start(dirs []string, threads int, fullStat bool) {
+	fmt.Fprintln(p.w, "Starting walk of", strings.Join(dirs, ", "), "with", strconv.FormatInt(int64(threads), 10), "threads and full stat mode =", fullStat)
+}
+
+func (p textPrinter) progress(snaps []snapshot, total snapshot) {
+	for _, s := range snaps {
+		state := "walking"
+		if s.Done {
+			state = "done"
+		}
+		fmt.Fprintln(p.w, "Found", s.Files, "files,", humanize.Bytes(uint64(s.Bytes)), "in", s.Duration, "under", s.Root, "("+state+")"+errorSummary(s.Errors))
+	}
+	if len(snaps) > 1 {
+		fmt.Fprintln(p.w, "Found", total.Files, "files,", humanize.Bytes(uint64(total.Bytes)), "in", total.Duration, "under all roots"+errorSummary(total.Errors))
+	}
+}
+
+func (p textPrinter) summary(snaps []snapshot, total snapshot, dist *distReport) {
+	for _, s := range snaps {
+		fmt.Fprintln(p.w, "Found a total of", s.Files, "files,", humanize.Bytes(uint64(s.Bytes)), "in", s.Duration, "under", s.Root)
+	}
+	fmt.Fprintln(p.w, "Found a total of", total.Files, "files,", humanize.Bytes(uint64(total.Bytes)), "in", total.Duration)
+	if dist != nil {
+		p.distribution(dist)
+	}
+	if total.Errors.total() > 0 {
+		p.errors(total)
+	}
+	for _, s := range snaps {
+		fmt.Fprintln(p.w, "Completed walk of", s.Root, ", err: ", s.Err)
+	}
+}
+
+// errorSummary returns ", n errors (class n, ...)", or "" if there are
+// no errors.
+func errorSummary(ec errorCounts) string {
+	n := ec.total()
+	if n == 0 {
+		return ""
+	}
+	var parts []string
+	for _, class := range ec.classes() {
+		parts = append(parts, class+" "+strconv.FormatInt(ec[class].Count, 10))
+	}
+	return fmt.Sprintf(", %d errors (%s)", n, strings.Join(parts, ", "))
+}
+
+func (p textPrinter) errors(total snapshot) {
+	fmt.Fprintln(p.w)
+	fmt.Fprintln(p.w, "Errors:", total.StatErrors, "stat,", total.WalkErrors, "walk")
+	for _, class := range total.Errors.classes() {
+		c := total.Errors[class]
+		fmt.Fprintf(p.w, "  %s: %d\n", class, c.Count)
+		for _, sample := range c.Samples {
+			fmt.Fprintln(p.w, "    "+sample)
+		}
+	}
+}
+
+func (p textPrinter) distribution(d *distReport) {
+	fmt.Fprintln(p.w)
+	tw := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SIZE\tFILES\tBYTES\t")
+	for _, b := range d.Sizes {
+		var label string
+		switch {
+		case b.Max == 1:
+			label = "0 B"
+		default:
+			label = humanize.IBytes(uint64(b.Min)) + " - " + humanize.IBytes(uint64(b.Max-1))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t\n", label, b.Files, humanize.IBytes(uint64(b.Bytes)))
+	}
+	tw.Flush()
+	fmt.Fprintln(p.w)
+	fmt.Fprintln(tw, "AGE\tMTIME FILES\tMTIME BYTES\tATIME FILES\tATIME BYTES\t")
+	for _, b := range d.Ages {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\t\n", b.Label,
+			b.Mtime.Files, humanize.IBytes(uint64(b.Mtime.Bytes)),
+			b.Atime.Files, humanize.IBytes(uint64(b.Atime.Bytes)))
+	}
+	for _, named := range []struct {
+		title  string
+		totals []namedTotal
+	}{
+		{"EXTENSION", d.Extensions},
+		{"OWNER", d.Owners},
+	} {
+		tw.Flush()
+		fmt.Fprintln(p.w)
+		fmt.Fprintf(tw, "%s\tFILES\tBYTES\t\n", named.title)
+		for i, t := range named.totals {
+			if i == p.top {
+				fmt.Fprintf(tw, "%d more\t\t\t\n", len(named.totals)-i)
+				break
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t\n", t.Name, t.Files, humanize.IBytes(uint64(t.Bytes)))
+		}
+	}
+	tw.Flush()
+}
+
+// jsonPrinter writes one JSON object per line. Each has a "type" of
+// "start", "progress" or "summary".
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+type startRecord struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Roots    []string  `json:"roots"`
+	Threads  int       `json:"threads"`
+	FullStat bool      `json:"full_stat"`
+}
+
+type reportRecord struct {
+	Type         string       `json:"type"`
+	Time         time.Time    `json:"time"`
+	Roots        []rootRecord `json:"roots"`
+	Total        rootRecord   `json:"total"`
+	Distribution *distReport  `json:"distribution,omitempty"` // summary only
+}
+
+type rootRecord struct {
+	Root            string      `json:"root"`
+	Files           int64       `json:"files"`
+	Bytes           int64       `json:"bytes"`
+	StatErrors      int64       `json:"stat_errors"`
+	WalkErrors      int64       `json:"walk_errors"`
+	Errors          errorCounts `json:"errors,omitempty"` // by class
+	DurationSeconds float64     `json:"duration_seconds"`
+	FilesPerSec     float64     `json:"files_per_sec"`
+	Done            bool        `json:"done"`
+	Error           string      `json:"error,omitempty"`
+}
+
+func newRootRecord(s snapshot) rootRecord {
+	r := rootRecord{
+		Root:            s.Root,
+		Files:           s.Files,
+		Bytes:           s.Bytes,
+		StatErrors:      s.StatErrors,
+		WalkErrors:      s.WalkErrors,
+		Errors:          s.Errors,
+		DurationSeconds: s.Duration.Seconds(),
+		FilesPerSec:     s.filesPerSec(),
+		Done:            s.Done,
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+	}
+	return r
+}
+
+func (p jsonPrinter) start(dirs []string, threads int, fullStat bool) {
+	p.enc.Encode(startRecord{Type: "start", Time: time.Now(), Roots: dirs, Threads: threads, FullStat: fullStat})
+}
+
+func (p jsonPrinter) report(typ string, snaps []snapshot, total snapshot, dist *distReport) {
+	rec := reportRecord{Type: typ, Time: time.Now(), Total: newRootRecord(total), Distribution: dist}
+	for _, s := range snaps {
+		rec.Roots = append(rec.Roots, newRootRecord(s))
+	}
+	p.enc.Encode(rec)
+}
+
+func (p jsonPrinter) progress(snaps []snapshot, total snapshot) {
+	p.report("progress", snaps, total, nil)
+}
+
+func (p jsonPrinter) summary(snaps []snapshot, total snapshot, dist *distReport) {
+	p.report("summary", snaps, total, dist)
+}
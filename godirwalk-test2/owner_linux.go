@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// ownerAndAtime returns the owner and access time of fi, if known.
+func ownerAndAtime(fi os.FileInfo) (uint32, time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return st.Uid, time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)), true
+}
@@ -0,0 +1,13 @@
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// ownerAndAtime returns the owner and access time of fi, if known.
+func ownerAndAtime(fi os.FileInfo) (uint32, time.Time, bool) {
+	return 0, time.Time{}, false
+}
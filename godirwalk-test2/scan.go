@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karrick/godirwalk"
+)
+
+// statJob is a path to stat, and the root it was found under.
+type statJob struct {
+	path string
+	root *rootStats
+}
+
+// busyFlag is 1 while a stat worker is handling a path. It is padded to
+// a cache line so workers do not contend for one.
+type busyFlag struct {
+	n int64
+	_ [7]int64
+}
+
+// scanner walks a set of roots and feeds the paths found to one pool of
+// stat workers shared by all of them. Every worker counts into its own
+// shard of each root's totals and fills its own distribution, so the
+// workers share no state but the job channel.
+type scanner struct {
+	roots      []*rootStats
+	workers    int
+	fullStat   bool
+	keepGoing  bool // skip directories that cannot be read
+	maxSamples int
+	t0         time.Time
+	dists      []*distribution // one per worker
+	busy       []busyFlag      // one per worker
+}
+
+func newScanner(dirs []string, workers int, fullStat, keepGoing bool, maxSamples int, t0 time.Time) *scanner {
+	s := &scanner{
+		roots:      make([]*rootStats, len(dirs)),
+		workers:    workers,
+		fullStat:   fullStat,
+		keepGoing:  keepGoing,
+		maxSamples: maxSamples,
+		t0:         t0,
+		dists:      make([]*distribution, workers),
+		busy:       make([]busyFlag, workers),
+	}
+	for i, dir := range dirs {
+		s.roots[i] = newRootStats(dir, workers, maxSamples, t0)
+	}
+	for i := range s.dists {
+		s.dists[i] = newDistribution(t0)
+	}
+	return s
+}
+
+// run walks all roots and returns once every path found has been
+// handled. The totals may be read with snapshots while it runs.
+func (s *scanner) run() {
+	var statWg sync.WaitGroup
+	statWg.Add(s.workers)
+	ch := make(chan statJob, s.workers)
+	for i := 0; i < s.workers; i++ {
+		go func(id int) {
+			defer statWg.Done()
+			s.stat(id, ch)
+		}(i)
+	}
+
+	var walkWg sync.WaitGroup
+	walkWg.Add(len(s.roots))
+	for _, r := range s.roots {
+		go func(r *rootStats) {
+			defer walkWg.Done()
+			s.walk(r, ch)
+		}(r)
+	}
+
+	walkWg.Wait()
+	close(ch)
+	statWg.Wait()
+}
+
+// walk sends the paths under the root of r to the stat workers.
+func (s *scanner) walk(r *rootStats, ch chan<- statJob) {
+	err := godirwalk.Walk(r.root, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			r.queue()
+			ch <- statJob{path: osPathname, root: r}
+			return nil
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			r.walkFailed(osPathname, err)
+			if s.keepGoing {
+				return godirwalk.SkipNode
+			}
+			return godirwalk.Halt
+		},
+		Unsorted: true, // (optional) set true for faster yet non-deterministic enumeration (see godoc)
+	})
+	r.walkDone(err)
+}
+
+// stat counts the paths received on ch into the shard of worker id and,
+// in full stat mode, adds them to its distribution.
+func (s *scanner) stat(id int, ch <-chan statJob) {
+	busy := &s.busy[id].n
+	dist := s.dists[id]
+	for job := range ch {
+		atomic.StoreInt64(busy, 1)
+		var err error
+		fileSize := int64(0)
+		if s.fullStat {
+			var fi os.FileInfo
+			if fi, err = os.Lstat(job.path); err == nil {
+				if !fi.IsDir() {
+					fileSize = fi.Size()
+				}
+				dist.add(job.path, fi)
+			}
+		}
+
+		job.root.counted(id, job.path, fileSize, err)
+		atomic.StoreInt64(busy, 0)
+	}
+}
+
+// active returns the number of stat workers handling a path.
+func (s *scanner) active() int64 {
+	var n int64
+	for i := range s.busy {
+		n += atomic.LoadInt64(&s.busy[i].n)
+	}
+	return n
+}
+
+// distribution merges the distributions of the workers. It must only be
+// called once run has returned, and returns nil unless in full stat
+// mode.
+func (s *scanner) distribution() *distReport {
+	if !s.fullStat {
+		return nil
+	}
+	merged := newDistribution(s.t0)
+	for _, d := range s.dists {
+		merged.merge(d)
+	}
+	return merged.report()
+}
+
+func snapshots(roots []*rootStats, now time.Time) []snapshot {
+	snaps := make([]snapshot, len(roots))
+	for i, r := range roots {
+		snaps[i] = r.snapshot(now)
+	}
+	return snaps
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counters are the totals of one stat worker for one root. Only that
+// worker writes them, and reporters read them, all atomically. They are
+// padded to a cache line so workers do not contend for one.
+type counters struct {
+	files      int64
+	bytes      int64
+	statErrors int64
+	done       int64 // paths handled, with or without error
+	lastDone   int64 // time the last path was handled, in Unix nanoseconds
+	_          [3]int64
+}
+
+// rootStats are the running totals of the walk of one root. The counts
+// are sharded by stat worker and only summed when reported, so counting
+// a path takes no lock.
+type rootStats struct {
+	// Atomically accessed; first for 64-bit alignment on 32-bit
+	// platforms.
+	queued  int64 // paths sent to the stat workers; written by the walk only
+	walkEnd int64 // time the walk returned, in Unix nanoseconds; 0 until then
+
+	root       string
+	maxSamples int // error samples kept per class
+	start      time.Time
+	shards     []counters // one per stat worker
+
+	mtx        sync.Mutex // guards the fields below, which change rarely
+	walkErrors int64
+	errors     errorCounts
+	err        error
+}
+
+func newRootStats(root string, workers, maxSamples int, start time.Time) *rootStats {
+	return &rootStats{
+		root:       root,
+		maxSamples: maxSamples,
+		start:      start,
+		shards:     make([]counters, workers),
+	}
+}
+
+// queue records that a path of the root is being sent to the stat
+// workers. It is only called by the walk of the root.
+func (r *rootStats) queue() {
+	atomic.AddInt64(&r.queued, 1)
+}
+
+// counted records a path handled by stat worker w. err is the error
+// from stat'ing the path, if any; such paths are left out of the
+// totals.
+func (r *rootStats) counted(w int, path string, size int64, err error) {
+	c := &r.shards[w]
+	if err == nil {
+		atomic.AddInt64(&c.files, 1)
+		atomic.AddInt64(&c.bytes, size)
+	} else {
+		atomic.AddInt64(&c.statErrors, 1)
+		r.mtx.Lock()
+		r.addError("stat", path, err)
+		r.mtx.Unlock()
+	}
+	// lastDone is stored before done is incremented, so a reporter that
+	// sees every path done also sees when the last one was.
+	atomic.StoreInt64(&c.lastDone, time.Now().UnixNano())
+	atomic.AddInt64(&c.done, 1)
+}
+
+// walkFailed records an error reading a directory of the walk.
+func (r *rootStats) walkFailed(path string, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.walkErrors++
+	r.addError("walk", path, err)
+}
+
+// addError records err. r.mtx must be held.
+func (r *rootStats) addError(op, path string, err error) {
+	if r.errors == nil {
+		r.errors = errorCounts{}
+	}
+	r.errors.add(op, path, err, r.maxSamples)
+}
+
+// walkDone records the end of the walk itself.
+func (r *rootStats) walkDone(err error) {
+	r.mtx.Lock()
+	r.err = err
+	r.mtx.Unlock()
+	atomic.StoreInt64(&r.walkEnd, time.Now().UnixNano())
+}
+
+// snapshot is a copy of the totals of a root, or of all roots
+// combined.
+type snapshot struct {
+	Root       string
+	Files      int64
+	Bytes      int64
+	StatErrors int64
+	WalkErrors int64
+	Errors     errorCounts // by class, of both kinds
+	Duration   time.Duration
+	Done       bool
+	Err        error
+
+	end time.Time // when Done
+}
+
+// snapshot sums the shards of r. The root is done once its walk has
+// returned and every path it queued has been handled.
+func (r *rootStats) snapshot(now time.Time) snapshot {
+	// The walk queues nothing after walkEnd is set, so queued is final
+	// if walkEnd is read first.
+	walkEnd := atomic.LoadInt64(&r.walkEnd)
+	queued := atomic.LoadInt64(&r.queued)
+
+	s := snapshot{Root: r.root}
+	var done, end int64
+	for i := range r.shards {
+		c := &r.shards[i]
+		d := atomic.LoadInt64(&c.done)
+		if d > 0 {
+			if t := atomic.LoadInt64(&c.lastDone); t > end {
+				end = t
+			}
+		}
+		done += d
+		s.Files += atomic.LoadInt64(&c.files)
+		s.Bytes += atomic.LoadInt64(&c.bytes)
+		s.StatErrors += atomic.LoadInt64(&c.statErrors)
+	}
+
+	r.mtx.Lock()
+	s.WalkErrors = r.walkErrors
+	s.Errors = r.errors.copy()
+	s.Err = r.err
+	r.mtx.Unlock()
+
+	s.Done = walkEnd != 0 && done == queued
+	if s.Done {
+		if walkEnd > end {
+			end = walkEnd
+		}
+		s.end = time.Unix(0, end)
+		s.Duration = s.end.Sub(r.start)
+	} else {
+		s.Duration = now.Sub(r.start)
+	}
+	return s
+}
+
+// filesPerSec returns the average rate at which files were found.
+func (s *snapshot) filesPerSec() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Files) / s.Duration.Seconds()
+}
+
+// combine returns the totals of all roots, timed from t0. At most
+// maxSamples errors of each class are kept.
+func combine(snaps []snapshot, t0, now time.Time, maxSamples int) snapshot {
+	total := snapshot{Root: "total", Done: true, Errors: errorCounts{}}
+	var end time.Time
+	for _, s := range snaps {
+		total.Files += s.Files
+		total.Bytes += s.Bytes
+		total.StatErrors += s.StatErrors
+		total.WalkErrors += s.WalkErrors
+		total.Errors.merge(s.Errors, maxSamples)
+		total.Done = total.Done && s.Done
+		if s.end.After(end) {
+			end = s.end
+		}
+	}
+	if total.Done {
+		total.Duration = end.Sub(t0)
+	} else {
+		total.Duration = now.Sub(t0)
+	}
+	return total
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makeTree creates dirs directories of files files each under root,
+// with file i of each directory i bytes long. It returns the number of
+// paths created, including root, and the bytes in the files.
+func makeTree(t *testing.T, root string, dirs, files int) (paths, bytes int64) {
+	t.Helper()
+	paths = 1
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%d", d))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		paths++
+		for f := 0; f < files; f++ {
+			name := filepath.Join(dir, fmt.Sprintf("f%d.txt", f))
+			if err := ioutil.WriteFile(name, make([]byte, f), 0644); err != nil {
+				t.Fatal(err)
+			}
+			paths++
+			bytes += int64(f)
+		}
+	}
+	return paths, bytes
+}
+
+// TestScanner drives the stat pipeline over several roots while the
+// totals are reported concurrently, as the progress reports and the
+// metrics endpoint do. Run it with -race.
+func TestScanner(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godirwalk-test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	const numRoots = 3
+	var dirs []string
+	var wantPaths, wantBytes int64
+	for i := 0; i < numRoots; i++ {
+		dir := filepath.Join(tmp, fmt.Sprintf("root%d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		p, b := makeTree(t, dir, 10, 50)
+		dirs = append(dirs, dir)
+		wantPaths += p
+		wantBytes += b
+	}
+
+	t0 := time.Now()
+	sc := newScanner(dirs, 8, true, false, 5, t0)
+	m := &metrics{scanner: sc}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				now := time.Now()
+				snaps := snapshots(sc.roots, now)
+				total := combine(snaps, t0, now, sc.maxSamples)
+				if total.Files > wantPaths || total.Bytes > wantBytes {
+					t.Errorf("counted %d files and %d bytes, more than the %d and %d in the tree",
+						total.Files, total.Bytes, wantPaths, wantBytes)
+				}
+				buf.Reset()
+				m.write(&buf, now)
+			}
+		}()
+	}
+
+	sc.run()
+	close(stop)
+	wg.Wait()
+
+	snaps := snapshots(sc.roots, time.Now())
+	for _, s := range snaps {
+		if !s.Done {
+			t.Errorf("%s: not done after run returned", s.Root)
+		}
+		if s.Err != nil || s.StatErrors != 0 || s.WalkErrors != 0 {
+			t.Errorf("%s: unexpected errors: %v, %d stat, %d walk", s.Root, s.Err, s.StatErrors, s.WalkErrors)
+		}
+		if want := wantPaths / numRoots; s.Files != want {
+			t.Errorf("%s: counted %d files; want %d", s.Root, s.Files, want)
+		}
+	}
+	total := combine(snaps, t0, time.Now(), sc.maxSamples)
+	if total.Files != wantPaths || total.Bytes != wantBytes {
+		t.Errorf("counted %d files and %d bytes; want %d and %d", total.Files, total.Bytes, wantPaths, wantBytes)
+	}
+	if n := sc.active(); n != 0 {
+		t.Errorf("%d workers still active", n)
+	}
+
+	dist := sc.distribution()
+	var distFiles int64
+	for _, b := range dist.Sizes {
+		distFiles += b.Files
+	}
+	if want := int64(numRoots * 10 * 50); distFiles != want {
+		t.Errorf("distribution holds %d files; want %d", distFiles, want)
+	}
+}
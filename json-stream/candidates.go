@@ -0,0 +1,218 @@
+package main
+
+import (
+	"sort"
+)
+
+func objectLess(a, b *object) bool {
+	if a.SizeInBytes != b.SizeInBytes {
+		return a.SizeInBytes < b.SizeInBytes
+	}
+	return a.Name < b.Name
+}
+
+// sortedSource is a sequence of objects in objectLess order that can be
+// read at any position.
+type sortedSource interface {
+	len() int
+	at(i int) (object, error)
+}
+
+// objectSlice is a sortedSource held in memory.
+type objectSlice []object
+
+func (s objectSlice) len() int                 { return len(s) }
+func (s objectSlice) at(i int) (object, error) { return s[i], nil }
+
+// sortObjects sorts objects in place into an objectSlice.
+func sortObjects(objects []object) objectSlice {
+	sort.Slice(objects, func(i, j int) bool { return objectLess(&objects[i], &objects[j]) })
+	return objects
+}
+
+// nodeObjects are the objects stored on a node. The node's original
+// objects come from sorted sources, in memory or on disk, which are
+// never modified: objects moved off the node are marked removed, and
+// objects moved onto it are kept in memory.
+type nodeObjects struct {
+	sources []sortedSource
+	removed []map[int]bool // removed positions of each source
+	lo      []int          // positions of each source before lo are all removed
+	moved   objectSlice    // objects moved onto the node, sorted
+	readErr error
+}
+
+func newNodeObjects(sources ...sortedSource) *nodeObjects {
+	return &nodeObjects{
+		sources: sources,
+		removed: make([]map[int]bool, len(sources)),
+		lo:      make([]int, len(sources)),
+	}
+}
+
+// candidate is an object of a node and where it is held.
+type candidate struct {
+	object
+	source int // index in sources, or len(sources) for moved
+	pos    int
+}
+
+// each calls fn with every source of n, including moved, its index and
+// the first position that may not be removed.
+func (n *nodeObjects) each(fn func(i int, s sortedSource, lo int)) {
+	for i, s := range n.sources {
+		fn(i, s, n.lo[i])
+	}
+	fn(len(n.sources), n.moved, 0)
+}
+
+func (n *nodeObjects) isRemoved(i, pos int) bool {
+	return i < len(n.sources) && n.removed[i][pos]
+}
+
+func (n *nodeObjects) read(s sortedSource, pos int) (object, bool) {
+	o, err := s.at(pos)
+	if err != nil {
+		if n.readErr == nil {
+			n.readErr = err
+		}
+		return object{}, false
+	}
+	return o, true
+}
+
+// search returns the first position of s from lo whose object
+// satisfies f, or s.len() if there is none.
+func (n *nodeObjects) search(s sortedSource, lo int, f func(o *object) bool) int {
+	return lo + sort.Search(s.len()-lo, func(i int) bool {
+		o, ok := n.read(s, lo+i)
+		return !ok || f(&o)
+	})
+}
+
+// fit restricts the objects a search may return to those of min to
+// max bytes, inclusive, for which ok, if set, returns true.
+type fit struct {
+	min, max int64
+	ok       func(o *object) bool
+}
+
+// walk returns the first object from position pos of source i,
+// stepping by step (1 or -1), that is not removed and fits f. It gives
+// up on leaving the positions of s from lo, or the sizes of f.
+func (n *nodeObjects) walk(i int, s sortedSource, lo, pos, step int, f fit) (candidate, bool) {
+	for ; pos >= lo && pos < s.len(); pos += step {
+		if n.isRemoved(i, pos) {
+			continue
+		}
+		o, ok := n.read(s, pos)
+		if !ok || (step > 0 && o.SizeInBytes > f.max) || (step < 0 && o.SizeInBytes < f.min) {
+			break
+		}
+		if o.SizeInBytes >= f.min && o.SizeInBytes <= f.max && (f.ok == nil || f.ok(&o)) {
+			return candidate{o, i, pos}, true
+		}
+	}
+	return candidate{}, false
+}
+
+// atLeast returns the smallest object that fits f.
+func (n *nodeObjects) atLeast(f fit) (candidate, bool) {
+	var best candidate
+	found := false
+	n.each(func(i int, s sortedSource, lo int) {
+		for lo < s.len() && n.isRemoved(i, lo) {
+			lo++
+		}
+		if i < len(n.sources) {
+			n.lo[i] = lo
+		}
+		// Usually every object is large enough, and the search is
+		// skipped.
+		pos := lo
+		if lo < s.len() {
+			if o, ok := n.read(s, lo); ok && o.SizeInBytes < f.min {
+				pos = n.search(s, lo, func(o *object) bool { return o.SizeInBytes >= f.min })
+			}
+		}
+		if c, ok := n.walk(i, s, lo, pos, 1, f); ok {
+			if !found || objectLess(&c.object, &best.object) {
+				best, found = c, true
+			}
+		}
+	})
+	return best, found && n.readErr == nil
+}
+
+// atMost returns the largest object that fits f.
+func (n *nodeObjects) atMost(f fit) (candidate, bool) {
+	var best candidate
+	found := false
+	n.each(func(i int, s sortedSource, lo int) {
+		pos := n.search(s, lo, func(o *object) bool { return o.SizeInBytes > f.max }) - 1
+		if c, ok := n.walk(i, s, lo, pos, -1, f); ok {
+			if !found || objectLess(&best.object, &c.object) {
+				best, found = c, true
+			}
+		}
+	})
+	return best, found && n.readErr == nil
+}
+
+// nearest returns the object that fits f whose size is closest to
+// target bytes, the smaller on a tie.
+func (n *nodeObjects) nearest(target int64, f fit) (candidate, bool) {
+	var best candidate
+	found := false
+	consider := func(c candidate) {
+		if !found {
+			best, found = c, true
+			return
+		}
+		d, bd := distance(c.SizeInBytes, target), distance(best.SizeInBytes, target)
+		if d < bd || (d == bd && objectLess(&c.object, &best.object)) {
+			best = c
+		}
+	}
+	n.each(func(i int, s sortedSource, lo int) {
+		p := n.search(s, lo, func(o *object) bool { return o.SizeInBytes >= target })
+		if c, ok := n.walk(i, s, lo, p, 1, f); ok {
+			consider(c)
+		}
+		if c, ok := n.walk(i, s, lo, p-1, -1, f); ok {
+			consider(c)
+		}
+	})
+	return best, found && n.readErr == nil
+}
+
+func distance(a, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// remove removes c, as returned by atLeast, atMost or nearest since the
+// last change to n.
+func (n *nodeObjects) remove(c candidate) {
+	if c.source == len(n.sources) {
+		n.moved = append(n.moved[:c.pos], n.moved[c.pos+1:]...)
+		return
+	}
+	if n.removed[c.source] == nil {
+		n.removed[c.source] = map[int]bool{}
+	}
+	n.removed[c.source][c.pos] = true
+}
+
+// add adds o, moved onto the node.
+func (n *nodeObjects) add(o object) {
+	i := sort.Search(len(n.moved), func(i int) bool { return objectLess(&o, &n.moved[i]) })
+	n.moved = append(n.moved, object{})
+	copy(n.moved[i+1:], n.moved[i:])
+	n.moved[i] = o
+}
+
+// err returns the error that ended a search early, if any.
+func (n *nodeObjects) err() error { return n.readErr }
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Problem is something wrong with an inventory.
+type Problem struct {
+	Offset int64  // of the node or object in the input, in bytes
+	Node   string // name of the node, if known
+	Object string // name of the object, for problems with an object
+	Reason string
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("offset %d", p.Offset)
+	if p.Node != "" {
+		s += fmt.Sprintf(": node '%s'", p.Node)
+	}
+	if p.Object != "" {
+		s += fmt.Sprintf(": object '%s'", p.Object)
+	}
+	return s + ": " + p.Reason
+}
+
+// ValidationError is returned for an invalid inventory. It lists every
+// problem found, up to the first that stopped decoding, if any, in the
+// order of their offsets.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("Invalid inventory, %d problem(s):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// inventoryDecoder decodes an inventory a node and an object at a time,
+// checking that:
+//
+//   - the inventory is an array of nodes, and nodes and objects are
+//     JSON objects with fields of the right types,
+//   - no capacity_bytes or size_bytes is negative,
+//   - no node stores more bytes than its capacity,
+//   - no two nodes, or two objects, have the same name,
+//   - max_utilization is in (0, 1], if given.
+type inventoryDecoder struct {
+	decoder  *json.Decoder
+	problems []Problem
+
+	nodeNames  map[string]int64 // offset of each node, by name
+	nodeNameOf []string         // name of each node decoded, by index
+	names      nameChecker      // of the objects
+}
+
+// newInventoryDecoder returns a decoder reading from reader, checking
+// object names for duplicates with names.
+func newInventoryDecoder(reader io.Reader, names nameChecker) *inventoryDecoder {
+	return &inventoryDecoder{
+		decoder:   json.NewDecoder(reader),
+		nodeNames: map[string]int64{},
+		names:     names,
+	}
+}
+
+func (d *inventoryDecoder) problem(offset int64, node, obj, reason string, args ...interface{}) {
+	d.problems = append(d.problems, Problem{
+		Offset: offset,
+		Node:   node,
+		Object: obj,
+		Reason: fmt.Sprintf(reason, args...),
+	})
+}
+
+// fail returns the problems found so far and a last one, at offset,
+// that stopped decoding.
+func (d *inventoryDecoder) fail(offset int64, reason string, args ...interface{}) error {
+	d.problem(offset, "", "", reason, args...)
+	return &ValidationError{Problems: d.problems}
+}
+
+// failErr is fail for an error of the decoder.
+func (d *inventoryDecoder) failErr(err error) error {
+	offset := d.decoder.InputOffset()
+	if serr, ok := err.(*json.SyntaxError); ok {
+		offset = serr.Offset
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return d.fail(offset, "%v", err)
+}
+
+// token returns the next token and the offset at which it ends.
+func (d *inventoryDecoder) token() (json.Token, int64, error) {
+	tok, err := d.decoder.Token()
+	if err != nil {
+		return nil, 0, d.failErr(err)
+	}
+	return tok, d.decoder.InputOffset(), nil
+}
+
+// expectDelim reads the next token, which must be want, and returns
+// its offset.
+func (d *inventoryDecoder) expectDelim(want json.Delim, what string) (int64, error) {
+	tok, end, err := d.token()
+	if err != nil {
+		return 0, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != want {
+		return 0, d.fail(end-1, "expected %s, found %s", what, describeToken(tok))
+	}
+	return end - 1, nil
+}
+
+func describeToken(tok json.Token) string {
+	switch t := tok.(type) {
+	case json.Delim:
+		return fmt.Sprintf("'%s'", t)
+	case string:
+		return fmt.Sprintf("string %q", t)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T %v", tok, tok)
+}
+
+// value decodes the next value into v. A value of the wrong type is
+// reported as a problem of node and obj, and decoding continues.
+func (d *inventoryDecoder) value(v interface{}, offset int64, node, obj, field string) (bool, error) {
+	err := d.decoder.Decode(v)
+	if terr, ok := err.(*json.UnmarshalTypeError); ok {
+		d.problem(offset, node, obj, "%s must be %s, found %s", field, terr.Type, terr.Value)
+		return false, nil
+	} else if err != nil {
+		return false, d.failErr(err)
+	}
+	return true, nil
+}
+
+// decode decodes the inventory, calling fn with each object and the
+// index of the node storing it, and returns the nodes without their
+// objects. If the inventory has problems, decode returns a
+// *ValidationError, having called fn for some or all objects, with the
+// problems in the order of their offsets. An empty input is reported as
+// io.EOF.
+func (d *inventoryDecoder) decode(fn func(node int, o object) error) ([]nodeState, error) {
+	nodes, err := d.nodes(fn)
+	if _, ok := err.(*ValidationError); err != nil && !ok {
+		return nil, err
+	}
+	// Duplicate names are only known once every object is seen.
+	err = d.names.duplicates(func(e nameEntry, first int64) {
+		node := ""
+		if e.node < len(d.nodeNameOf) {
+			node = d.nodeNameOf[e.node]
+		}
+		d.problem(e.offset, node, e.name, "duplicate object name, first used at offset %d", first)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(d.problems) > 0 {
+		sort.SliceStable(d.problems, func(i, j int) bool { return d.problems[i].Offset < d.problems[j].Offset })
+		return nil, &ValidationError{Problems: d.problems}
+	}
+	return nodes, nil
+}
+
+// nodes decodes the array of nodes. It returns a *ValidationError for a
+// problem that stops decoding; other problems are only recorded.
+func (d *inventoryDecoder) nodes(fn func(node int, o object) error) ([]nodeState, error) {
+	tok, err := d.decoder.Token()
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, d.failErr(err)
+	}
+	if tok != json.Delim('[') {
+		return nil, d.fail(d.decoder.InputOffset()-1, "expected an array of nodes, found %s", describeToken(tok))
+	}
+
+	nodes := []nodeState{}
+	for d.decoder.More() {
+		n, err := d.node(len(nodes), fn)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if _, err := d.expectDelim(']', "the end of the array of nodes"); err != nil {
+		return nil, err
+	}
+	if tok, err := d.decoder.Token(); err != io.EOF {
+		if err != nil {
+			return nil, d.failErr(err)
+		}
+		return nil, d.fail(d.decoder.InputOffset()-1, "unexpected %s after the array of nodes", describeToken(tok))
+	}
+	return nodes, nil
+}
+
+// node decodes the node at the decoder's position.
+func (d *inventoryDecoder) node(id int, fn func(node int, o object) error) (nodeState, error) {
+	n := node{}
+	offset, err := d.expectDelim('{', "a node")
+	if err != nil {
+		return nodeState{}, err
+	}
+
+	// The name may come after the objects; problems found before it
+	// are named once the node is done.
+	firstProblem := len(d.problems)
+	usedInBytes := int64(0)
+	for d.decoder.More() {
+		key, _, err := d.token()
+		if err != nil {
+			return nodeState{}, err
+		}
+		switch key {
+		case "name":
+			_, err = d.value(&n.Name, offset, n.Name, "", "name")
+		case "capacity_bytes":
+			_, err = d.value(&n.CapacityInBytes, offset, n.Name, "", "capacity_bytes")
+		case "zone":
+			_, err = d.value(&n.Zone, offset, n.Name, "", "zone")
+		case "rack":
+			_, err = d.value(&n.Rack, offset, n.Name, "", "rack")
+		case "max_utilization":
+			_, err = d.value(&n.MaxUtilization, offset, n.Name, "", "max_utilization")
+		case "objects":
+			err = d.objects(id, func(o object) error {
+				usedInBytes += o.SizeInBytes
+				return fn(id, o)
+			})
+		default:
+			var skip json.RawMessage
+			err = d.decoder.Decode(&skip)
+			if err != nil {
+				err = d.failErr(err)
+			}
+		}
+		if err != nil {
+			return nodeState{}, err
+		}
+	}
+	if _, err := d.expectDelim('}', "the end of a node"); err != nil {
+		return nodeState{}, err
+	}
+
+	for i := firstProblem; i < len(d.problems); i++ {
+		d.problems[i].Node = n.Name
+	}
+	if n.CapacityInBytes < 0 {
+		d.problem(offset, n.Name, "", "negative capacity_bytes %d", n.CapacityInBytes)
+	} else if usedInBytes > n.CapacityInBytes {
+		d.problem(offset, n.Name, "", "capacity_bytes %d is less than the %d bytes of its objects", n.CapacityInBytes, usedInBytes)
+	}
+	if n.MaxUtilization < 0 || n.MaxUtilization > 1 {
+		d.problem(offset, n.Name, "", "max_utilization %v is not in (0, 1]", n.MaxUtilization)
+	}
+	if first, ok := d.nodeNames[n.Name]; ok {
+		d.problem(offset, n.Name, "", "duplicate node name, first used at offset %d", first)
+	} else {
+		d.nodeNames[n.Name] = offset
+	}
+	d.nodeNameOf = append(d.nodeNameOf, n.Name)
+
+	return newNodeState(&n, usedInBytes), nil
+}
+
+// objects calls fn for each valid object of the array at the decoder's
+// position, stored on the node with index id. A null array has no
+// objects.
+func (d *inventoryDecoder) objects(id int, fn func(o object) error) error {
+	tok, end, err := d.token()
+	if err != nil {
+		return err
+	} else if tok == nil {
+		return nil
+	} else if tok != json.Delim('[') {
+		return d.fail(end-1, "objects must be an array, found %s", describeToken(tok))
+	}
+
+	for d.decoder.More() {
+		o, valid, err := d.object(id)
+		if err != nil {
+			return err
+		}
+		if valid {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = d.expectDelim(']', "the end of the objects")
+	return err
+}
+
+// object decodes the object at the decoder's position, stored on the
+// node with index id, and reports whether it is valid.
+func (d *inventoryDecoder) object(id int) (object, bool, error) {
+	o := object{}
+	offset, err := d.expectDelim('{', "an object")
+	if err != nil {
+		return o, false, err
+	}
+
+	valid := true
+	for d.decoder.More() {
+		key, _, err := d.token()
+		if err != nil {
+			return o, false, err
+		}
+		ok := true
+		switch key {
+		case "name":
+			ok, err = d.value(&o.Name, offset, "", o.Name, "name")
+		case "size_bytes":
+			ok, err = d.value(&o.SizeInBytes, offset, "", o.Name, "size_bytes")
+		case "pinned":
+			ok, err = d.value(&o.Pinned, offset, "", o.Name, "pinned")
+		case "replica_group":
+			ok, err = d.value(&o.ReplicaGroup, offset, "", o.Name, "replica_group")
+		default:
+			var skip json.RawMessage
+			if err = d.decoder.Decode(&skip); err != nil {
+				err = d.failErr(err)
+			}
+		}
+		if err != nil {
+			return o, false, err
+		}
+		valid = valid && ok
+	}
+	if _, err := d.expectDelim('}', "the end of an object"); err != nil {
+		return o, false, err
+	}
+
+	if o.SizeInBytes < 0 {
+		d.problem(offset, "", o.Name, "negative size_bytes %d", o.SizeInBytes)
+		valid = false
+	}
+	if err := d.names.add(o.Name, id, offset); err != nil {
+		return o, false, err
+	}
+	return o, valid, nil
+}
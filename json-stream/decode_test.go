@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeBoth runs JsonDecode and JsonStream on input and checks that
+// they fail alike, returning the problems.
+func decodeBoth(t *testing.T, input string) []Problem {
+	t.Helper()
+	errDecode := JsonDecode(strings.NewReader(input), ioutil.Discard, nil)
+	errStream := JsonStream(strings.NewReader(input), ioutil.Discard, &Options{SpillDir: t.TempDir(), MaxInMemory: 1})
+	assert.Equal(t, errDecode, errStream)
+	verr, ok := errDecode.(*ValidationError)
+	if !assert.True(t, ok, "got %v, want a *ValidationError", errDecode) {
+		return nil
+	}
+	return verr.Problems
+}
+
+func TestValidate_TopLevelObject(t *testing.T) {
+	assert.Equal(t, []Problem{
+		{Offset: 0, Reason: "expected an array of nodes, found '{'"},
+	}, decodeBoth(t, "{}"))
+	assert.Equal(t, []Problem{
+		{Offset: 2, Reason: "expected an array of nodes, found '{'"},
+	}, decodeBoth(t, "  {\"name\": \"n1\"}"))
+}
+
+func TestValidate_NegativeSize(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":10,"objects":[{"name":"o1","size_bytes":1},{"name":"o2","size_bytes":-5}]}]`
+	assert.Equal(t, []Problem{
+		{Offset: int64(strings.Index(input, `{"name":"o2"`)), Node: "n1", Object: "o2", Reason: "negative size_bytes -5"},
+	}, decodeBoth(t, input))
+}
+
+func TestValidate_NegativeCapacity(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":-1}]`
+	assert.Equal(t, []Problem{
+		{Offset: 1, Node: "n1", Reason: "negative capacity_bytes -1"},
+	}, decodeBoth(t, input))
+}
+
+func TestValidate_CapacityBelowObjects(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":10,"objects":[]},` +
+		`{"objects":[{"name":"o1","size_bytes":6},{"name":"o2","size_bytes":6}],"capacity_bytes":11,"name":"n2"}]`
+	assert.Equal(t, []Problem{
+		{Offset: int64(strings.Index(input, `{"objects"`)), Node: "n2", Reason: "capacity_bytes 11 is less than the 12 bytes of its objects"},
+	}, decodeBoth(t, input))
+}
+
+func TestValidate_DuplicateNames(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":10,"objects":[{"name":"o1","size_bytes":1}]},` +
+		`{"name":"n2","capacity_bytes":10,"objects":[{"name":"o1","size_bytes":2}]},` +
+		`{"name":"n1","capacity_bytes":10}]`
+	o1 := int64(strings.Index(input, `{"name":"o1"`))
+	o1Dup := int64(strings.LastIndex(input, `{"name":"o1"`))
+	n1Dup := int64(strings.LastIndex(input, `{"name":"n1"`))
+	assert.Equal(t, []Problem{
+		{Offset: o1Dup, Node: "n2", Object: "o1", Reason: "duplicate object name, first used at offset " + strconv.FormatInt(o1, 10)},
+		{Offset: n1Dup, Node: "n1", Reason: "duplicate node name, first used at offset 1"},
+	}, decodeBoth(t, input))
+}
+
+// TestValidate_DuplicateObjectNames checks that in stream mode
+// duplicates are found across spilled runs, with every later object
+// naming the first.
+func TestValidate_DuplicateObjectNames(t *testing.T) {
+	input := `[{"objects":[{"name":"b","size_bytes":1},{"name":"a","size_bytes":1},{"name":"c","size_bytes":1}],"capacity_bytes":10,"name":"n1"},` +
+		`{"name":"n2","capacity_bytes":10,"objects":[{"name":"a","size_bytes":1},{"name":"d","size_bytes":1},{"name":"a","size_bytes":1}]}]`
+	var a []int64
+	for i := 0; ; {
+		j := strings.Index(input[i:], `{"name":"a"`)
+		if j < 0 {
+			break
+		}
+		a = append(a, int64(i+j))
+		i += j + 1
+	}
+	first := "duplicate object name, first used at offset " + strconv.FormatInt(a[0], 10)
+	assert.Equal(t, []Problem{
+		{Offset: a[1], Node: "n2", Object: "a", Reason: first},
+		{Offset: a[2], Node: "n2", Object: "a", Reason: first},
+	}, decodeBoth(t, input))
+}
+
+func TestValidate_WrongTypes(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":"10","objects":[{"name":"o1","size_bytes":1.5}]}]`
+	assert.Equal(t, []Problem{
+		{Offset: 1, Node: "n1", Reason: "capacity_bytes must be int64, found string"},
+		{Offset: int64(strings.Index(input, `{"name":"o1"`)), Node: "n1", Object: "o1", Reason: "size_bytes must be int64, found number 1.5"},
+	}, decodeBoth(t, input))
+}
+
+func TestValidate_Placement(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":10,"max_utilization":1.5},` +
+		`{"name":"n2","capacity_bytes":10,"zone":1,"objects":[{"name":"o1","size_bytes":1,"pinned":"yes"}]}]`
+	assert.Equal(t, []Problem{
+		{Offset: 1, Node: "n1", Reason: "max_utilization 1.5 is not in (0, 1]"},
+		{Offset: int64(strings.Index(input, `{"name":"n2"`)), Node: "n2", Reason: "zone must be string, found number"},
+		{Offset: int64(strings.Index(input, `{"name":"o1"`)), Node: "n2", Object: "o1", Reason: "pinned must be bool, found string"},
+	}, decodeBoth(t, input))
+}
+
+func TestValidate_EveryProblem(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":1,"objects":[{"name":"o1","size_bytes":-1},{"name":"o1","size_bytes":2}]},{"name":"n1"}]`
+	problems := decodeBoth(t, input)
+	assert.Len(t, problems, 4)
+}
+
+func TestValidate_Syntax(t *testing.T) {
+	problems := decodeBoth(t, `[{"name":"n1",}]`)
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, int64(14), problems[0].Offset)
+	}
+	problems = decodeBoth(t, `[{"name":"n1"}`)
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, "unexpected end of JSON input", problems[0].Reason)
+	}
+	problems = decodeBoth(t, `[] []`)
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, Problem{Offset: 3, Reason: "unexpected '[' after the array of nodes"}, problems[0])
+	}
+	problems = decodeBoth(t, `[{"name":"n1","objects":{}}]`)
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, Problem{Offset: 24, Reason: "objects must be an array, found '{'"}, problems[0])
+	}
+}
+
+func TestValidate_ErrorMessage(t *testing.T) {
+	err := JsonDecode(bytes.NewBufferString(`[{"name":"n1","capacity_bytes":-1}]`), ioutil.Discard, nil)
+	assert.EqualError(t, err, "Invalid inventory, 1 problem(s):\noffset 1: node 'n1': negative capacity_bytes -1")
+}
+
+func TestValidate_Valid(t *testing.T) {
+	input := `[{"name":"n1","capacity_bytes":10,"objects":null,"labels":{"zone":"a"}},{"name":"n2","capacity_bytes":0}]`
+	assert.Nil(t, JsonDecode(strings.NewReader(input), ioutil.Discard, nil))
+	assert.Nil(t, JsonStream(strings.NewReader(input), ioutil.Discard, nil))
+}
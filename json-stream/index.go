@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// indexEntry is an object of the index and the node storing it.
+type indexEntry struct {
+	node int
+	object
+}
+
+func entryLess(a, b *indexEntry) bool {
+	if a.node != b.node {
+		return a.node < b.node
+	}
+	return objectLess(&a.object, &b.object)
+}
+
+// objectIndex orders the objects of all nodes by node, then size. It is
+// an external sort: objects are buffered in memory, and each time the
+// buffer fills it is sorted and spilled to temporary files as a run.
+// Once every object has been added, the objects of a node are searched
+// in its part of each run, without reading the runs back into memory.
+type objectIndex struct {
+	dir         string
+	maxInMemory int
+	buf         []indexEntry // sorted once finished
+	runs        []*indexRun
+}
+
+// indexRun is a sorted run spilled to disk. Its records have a fixed
+// size, so they can be binary searched; the names and replica groups
+// they refer to are kept in a separate file.
+type indexRun struct {
+	records *os.File
+	names   *os.File
+	nodes   []int // nodes with objects in the run, ascending
+	starts  []int // starts[i] is the first record of nodes[i]; the last is the number of records
+}
+
+// An index record is the size of the object, the offset of its name in
+// the names file, the length of the name and the length of its replica
+// group, which follows the name.
+const indexRecordSize = 8 + 8 + 4 + 4
+
+func newObjectIndex(dir string, maxInMemory int) *objectIndex {
+	if maxInMemory < 1 {
+		maxInMemory = 1
+	}
+	return &objectIndex{dir: dir, maxInMemory: maxInMemory}
+}
+
+func (x *objectIndex) add(node int, o object) error {
+	x.buf = append(x.buf, indexEntry{node: node, object: o})
+	if len(x.buf) >= x.maxInMemory {
+		return x.spill()
+	}
+	return nil
+}
+
+func (x *objectIndex) sortBuf() {
+	sort.Slice(x.buf, func(i, j int) bool { return entryLess(&x.buf[i], &x.buf[j]) })
+}
+
+// spill writes the buffer to a new run and empties it.
+func (x *objectIndex) spill() error {
+	x.sortBuf()
+	r := &indexRun{}
+	x.runs = append(x.runs, r)
+	var err error
+	if r.records, err = ioutil.TempFile(x.dir, "json-stream-index-"); err != nil {
+		return err
+	}
+	if r.names, err = ioutil.TempFile(x.dir, "json-stream-names-"); err != nil {
+		return err
+	}
+
+	rw := bufio.NewWriter(r.records)
+	nw := bufio.NewWriter(r.names)
+	var rec [indexRecordSize]byte
+	nameOff := int64(0)
+	for i := range x.buf {
+		e := &x.buf[i]
+		if len(r.nodes) == 0 || r.nodes[len(r.nodes)-1] != e.node {
+			r.nodes = append(r.nodes, e.node)
+			r.starts = append(r.starts, i)
+		}
+		binary.LittleEndian.PutUint64(rec[0:], uint64(e.SizeInBytes))
+		binary.LittleEndian.PutUint64(rec[8:], uint64(nameOff))
+		binary.LittleEndian.PutUint32(rec[16:], uint32(len(e.Name)))
+		binary.LittleEndian.PutUint32(rec[20:], uint32(len(e.ReplicaGroup)))
+		if _, err := rw.Write(rec[:]); err != nil {
+			return err
+		}
+		if _, err := nw.WriteString(e.Name); err != nil {
+			return err
+		}
+		if _, err := nw.WriteString(e.ReplicaGroup); err != nil {
+			return err
+		}
+		nameOff += int64(len(e.Name) + len(e.ReplicaGroup))
+	}
+	r.starts = append(r.starts, len(x.buf))
+	x.buf = x.buf[:0]
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	return nw.Flush()
+}
+
+// finish sorts the objects left in memory. No objects may be added
+// afterwards.
+func (x *objectIndex) finish() error {
+	x.sortBuf()
+	return nil
+}
+
+// objects returns the objects of node.
+func (x *objectIndex) objects(node int) *nodeObjects {
+	var sources []sortedSource
+	lo := sort.Search(len(x.buf), func(i int) bool { return x.buf[i].node >= node })
+	hi := sort.Search(len(x.buf), func(i int) bool { return x.buf[i].node > node })
+	if lo < hi {
+		sources = append(sources, entrySlice(x.buf[lo:hi]))
+	}
+	for _, r := range x.runs {
+		if i := sort.SearchInts(r.nodes, node); i < len(r.nodes) && r.nodes[i] == node {
+			sources = append(sources, &runSource{
+				run:   r,
+				start: r.starts[i],
+				n:     r.starts[i+1] - r.starts[i],
+				last:  -1,
+			})
+		}
+	}
+	return newNodeObjects(sources...)
+}
+
+// Close removes the spilled runs.
+func (x *objectIndex) Close() error {
+	var firstErr error
+	for _, r := range x.runs {
+		for _, f := range []*os.File{r.records, r.names} {
+			if f == nil {
+				continue
+			}
+			if err := f.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if err := os.Remove(f.Name()); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	x.runs = nil
+	return firstErr
+}
+
+// entrySlice is a sortedSource of the entries of one node in memory.
+type entrySlice []indexEntry
+
+func (s entrySlice) len() int                 { return len(s) }
+func (s entrySlice) at(i int) (object, error) { return s[i].object, nil }
+
+// runSource is a sortedSource of the records of one node in a run. It
+// remembers the last record read, which is often read again.
+type runSource struct {
+	run      *indexRun
+	start, n int
+	last     int
+	lastObj  object
+}
+
+func (s *runSource) len() int { return s.n }
+
+func (s *runSource) at(i int) (object, error) {
+	if i == s.last {
+		return s.lastObj, nil
+	}
+	var rec [indexRecordSize]byte
+	if _, err := s.run.records.ReadAt(rec[:], int64(s.start+i)*indexRecordSize); err != nil {
+		return object{}, err
+	}
+	nameLen := binary.LittleEndian.Uint32(rec[16:])
+	name := make([]byte, nameLen+binary.LittleEndian.Uint32(rec[20:]))
+	if _, err := s.run.names.ReadAt(name, int64(binary.LittleEndian.Uint64(rec[8:]))); err != nil {
+		return object{}, err
+	}
+	s.last = i
+	s.lastObj = object{
+		Name:         string(name[:nameLen]),
+		SizeInBytes:  int64(binary.LittleEndian.Uint64(rec[0:])),
+		ReplicaGroup: string(name[nameLen:]),
+	}
+	return s.lastObj, nil
+}
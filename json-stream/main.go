@@ -1,114 +1,97 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"flag"
 	"io"
 	"log"
 	"os"
-
-	"github.com/dustin/go-humanize"
 )
 
+// node is a node of an inventory, without its "objects" array: objects
+// are handed out one at a time as they are decoded; see
+// inventoryDecoder.
 type node struct {
-	Name                      string   `json:"name"`
-	CapacityInBytes           int64    `json:"capacity_bytes"`
-	Objects                   []object `json:"objects"`
-	freeSpaceInBytes          int64
-	smallestObjectIndex       int
-	smallestObjectSizeInBytes int64
+	Name            string `json:"name"`
+	CapacityInBytes int64  `json:"capacity_bytes"`
+
+	// Optional placement fields.
+	Zone string `json:"zone"` // failure domain
+	Rack string `json:"rack"` // failure domain within the zone
+	// MaxUtilization is the fraction of the capacity, in (0, 1], that
+	// moves may fill. Zero means 1.
+	MaxUtilization float64 `json:"max_utilization"`
 }
 
 type object struct {
 	Name        string `json:"name"`
 	SizeInBytes int64  `json:"size_bytes"`
-}
 
-func JsonDecode(reader io.Reader, writer io.Writer) error {
-	decoder := json.NewDecoder(reader)
+	// Optional placement fields.
+	Pinned bool `json:"pinned"` // never moved
+	// ReplicaGroup names the group of replicas the object belongs to.
+	// No two replicas of a group are placed in one failure domain.
+	ReplicaGroup string `json:"replica_group"`
+}
 
-	// Read first token (e.g., '[')
-	if _, err := jsonDecodeNextToken(decoder); err != nil {
+// JsonDecode reads an inventory of nodes and their objects and writes a
+// plan of the moves that balance the utilization of the nodes, followed
+// by a summary of the free space of each node before and after. opts may
+// be nil for the defaults.
+func JsonDecode(reader io.Reader, writer io.Writer, opts *Options) error {
+	sysState := [][]object{}
+	replicas := newPlacement()
+	nodes, err := newInventoryDecoder(reader, newNameSet()).decode(func(i int, o object) error {
+		replicas.add(i, &o)
+		if o.Pinned {
+			return nil
+		}
+		for len(sysState) <= i {
+			sysState = append(sysState, nil)
+		}
+		sysState[i] = append(sysState[i], o)
+		return nil
+	})
+	if err != nil {
 		return err
 	}
+	replicas.finish(nodes)
 
-	sysState := []node{}
-	for decoder.More() {
-		node := node{}
-		err := decoder.Decode(&node)
-		switch err {
-		case io.EOF, nil:
-			sysState = append(sysState, node)
-		default:
-			return err
+	for i := range nodes {
+		var objects []object
+		if i < len(sysState) {
+			objects = sysState[i]
 		}
+		nodes[i].objects = newNodeObjects(sortObjects(objects))
 	}
+	return rebalance(nodes, replicas, writer, opts.withDefaults())
+}
 
-	// Read last token (e.g., ']')
-	if _, err := jsonDecodeNextToken(decoder); err != nil {
-		return err
-	}
-
-	for {
-		maxFreeSpaceInBytes := int64(-1)
-		minFreeSpaceInBytes := int64(-1)
-		maxFreeSpaceNodeIndex := -1
-		minFreeSpaceNodeIndex := -1
-
-		for i, n := range sysState {
-			n.freeSpaceInBytes = n.CapacityInBytes
-			if len(n.Objects) > 0 {
-				n.smallestObjectIndex = 0
-				n.smallestObjectSizeInBytes = n.Objects[0].SizeInBytes
-			}
-			for j, o := range n.Objects {
-				n.freeSpaceInBytes -= o.SizeInBytes
-				if o.SizeInBytes < n.smallestObjectSizeInBytes {
-					n.smallestObjectIndex = j
-					n.smallestObjectSizeInBytes = o.SizeInBytes
-				}
-			}
-			if n.freeSpaceInBytes > maxFreeSpaceInBytes {
-				maxFreeSpaceInBytes = n.freeSpaceInBytes
-				maxFreeSpaceNodeIndex = i
-			}
-			if n.freeSpaceInBytes < minFreeSpaceInBytes || minFreeSpaceInBytes == -1 {
-				minFreeSpaceInBytes = n.freeSpaceInBytes
-				minFreeSpaceNodeIndex = i
-			}
-		}
+func main() {
+	stream := flag.Bool("stream", false, "stream the inventory instead of loading it into memory")
+	spillDir := flag.String("spill-dir", "", "directory for the object index spilled to disk in stream mode (default: system temporary directory)")
+	maxInMemory := flag.Int("max-objects", DefaultMaxInMemory, "objects, and object names, kept in memory in stream mode before spilling to disk")
+	threshold := flag.String("threshold", DefaultThreshold.String(), "utilization spread at which nodes are balanced, as a percentage (e.g. 5%) or in bytes of the mean node capacity (e.g. 1TiB)")
+	strategy := flag.String("strategy", Smallest.String(), "object to move: smallest (min-bytes), largest-fit (min-moves) or best-fit")
+	format := flag.String("format", Text.String(), "plan format: text, jsonl or csv")
+	flag.Parse()
 
-		if (maxFreeSpaceInBytes - minFreeSpaceInBytes) > humanize.TiByte {
-			minNode := &sysState[minFreeSpaceNodeIndex]
-			minObj := minNode.Objects[minNode.smallestObjectIndex]
-			//fmt.Fprintf(writer, "Min node: %s, min object: %s\n", minNode.Name, minObj.Name)
-			fmt.Fprintf(os.Stdout, "%s %s %s\n", sysState[minFreeSpaceNodeIndex].Name, sysState[maxFreeSpaceNodeIndex].Name, minObj.Name)
-			maxNode := &sysState[maxFreeSpaceNodeIndex]
-			maxNode.Objects = append(maxNode.Objects, minObj)
-			minNode.Objects = append(minNode.Objects[:minNode.smallestObjectIndex], minNode.Objects[minNode.smallestObjectIndex+1:]...)
-			//fmt.Fprintf(writer, "Min node objects: %v, max node objects: %v\n", minNode.Objects, maxNode.Objects)
-		} else {
-			fmt.Fprintf(writer, "Final free space diff: %s\n", humanize.Comma(maxFreeSpaceInBytes-minFreeSpaceInBytes))
-			fmt.Fprintf(writer, "Final system state: %v\n", sysState)
-			break
-		}
+	opts := &Options{SpillDir: *spillDir, MaxInMemory: *maxInMemory}
+	var err error
+	if opts.Format, err = ParseFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Threshold, err = ParseThreshold(*threshold); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Strategy, err = ParseStrategy(*strategy); err != nil {
+		log.Fatal(err)
 	}
 
-	return nil
-}
-
-func jsonDecodeNextToken(decoder *json.Decoder) (string, error) {
-	if tok, err := decoder.Token(); err != nil {
-		return "", err
-	} else if delim, ok := tok.(json.Delim); !ok {
-		return "", fmt.Errorf("Invalid JSON token '%s'", delim.String())
+	if *stream {
+		err = JsonStream(os.Stdin, os.Stdout, opts)
 	} else {
-		return delim.String(), nil
+		err = JsonDecode(os.Stdin, os.Stdout, opts)
 	}
-}
-
-func main() {
-	err := JsonDecode(os.Stdin, os.Stdout)
 	if err != nil {
 		log.Fatal(err)
 	}
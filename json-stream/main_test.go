@@ -10,22 +10,88 @@ import (
 )
 
 func TestJsonDecode_StdinArray(t *testing.T) {
-	assert.Nil(t, JsonDecode(bytes.NewBuffer([]byte("[{}]")), os.Stdout))
+	assert.Nil(t, JsonDecode(bytes.NewBuffer([]byte("[{}]")), os.Stdout, nil))
 }
 
 func TestJsonDecode_StdinEmpty(t *testing.T) {
 	reader := bytes.NewBuffer(nil)
-	assert.Equal(t, io.EOF, JsonDecode(reader, os.Stdout))
+	assert.Equal(t, io.EOF, JsonDecode(reader, os.Stdout, nil))
 }
 
 func TestJsonDecode_StdinEmptyArray(t *testing.T) {
-	assert.Nil(t, JsonDecode(bytes.NewBuffer([]byte("[]")), os.Stdout))
+	assert.Nil(t, JsonDecode(bytes.NewBuffer([]byte("[]")), os.Stdout, nil))
 }
 
 func TestJsonDecode_StdinEmptyObject(t *testing.T) {
-	assert.NotNil(t, io.EOF, JsonDecode(bytes.NewBuffer([]byte("{}")), os.Stdout))
+	assert.NotNil(t, JsonDecode(bytes.NewBuffer([]byte("{}")), os.Stdout, nil))
 }
 
 func TestJsonDecode_StdinMalformedArray(t *testing.T) {
-	assert.NotNil(t, JsonDecode(bytes.NewBuffer([]byte("[}")), os.Stdout))
+	assert.NotNil(t, JsonDecode(bytes.NewBuffer([]byte("[}")), os.Stdout, nil))
+}
+
+func TestJsonStream_SystemJson(t *testing.T) {
+	for _, maxInMemory := range []int{1, 2, 1000} {
+		f, err := os.Open("system.json")
+		assert.Nil(t, err)
+		var out bytes.Buffer
+		assert.Nil(t, JsonStream(f, &out, &Options{SpillDir: t.TempDir(), MaxInMemory: maxInMemory}))
+		f.Close()
+		assert.Equal(t, "n2 n1 o2\n"+
+			"Final free space diff: 250,000,000,000\n"+
+			"Final free space: n1 750,000,000,000\n"+
+			"Final free space: n2 500,000,000,000\n", out.String())
+	}
+}
+
+func TestJsonStream_StdinEmptyArray(t *testing.T) {
+	assert.Nil(t, JsonStream(bytes.NewBuffer([]byte("[]")), os.Stdout, &Options{MaxInMemory: 1}))
+}
+
+func TestJsonStream_StdinEmptyObject(t *testing.T) {
+	assert.NotNil(t, JsonStream(bytes.NewBuffer([]byte("{}")), os.Stdout, &Options{MaxInMemory: 1}))
+}
+
+func TestJsonStream_StdinMalformedArray(t *testing.T) {
+	assert.NotNil(t, JsonStream(bytes.NewBuffer([]byte("[}")), os.Stdout, &Options{MaxInMemory: 1}))
+}
+
+func TestJsonDecode_FormatJSONL(t *testing.T) {
+	f, err := os.Open("system.json")
+	assert.Nil(t, err)
+	defer f.Close()
+	var out bytes.Buffer
+	assert.Nil(t, JsonDecode(f, &out, &Options{Format: JSONL}))
+	assert.Equal(t, `{"type":"move","src_node":"n2","dst_node":"n1","object":"o2","size_bytes":500000000000,"cumulative_bytes":500000000000}
+{"type":"summary","moves":1,"bytes_moved":500000000000,"threshold_utilization":0.137438953472,"spread_before_bytes":1250000000000,"spread_after_bytes":250000000000,"utilization_spread_before":0.15625,"utilization_spread_after":0.03125,"nodes":[{"node":"n1","capacity_bytes":8000000000000,"free_before_bytes":1250000000000,"free_after_bytes":750000000000,"utilization_before":0.84375,"utilization_after":0.90625},{"node":"n2","capacity_bytes":8000000000000,"free_before_bytes":0,"free_after_bytes":500000000000,"utilization_before":1,"utilization_after":0.9375}]}
+`, out.String())
+}
+
+func TestJsonDecode_FormatCSV(t *testing.T) {
+	f, err := os.Open("system.json")
+	assert.Nil(t, err)
+	defer f.Close()
+	var out bytes.Buffer
+	assert.Nil(t, JsonDecode(f, &out, &Options{Format: CSV}))
+	assert.Equal(t, `type,src_node,dst_node,object,size_bytes,cumulative_bytes,zone,rack,capacity_bytes,free_before_bytes,free_after_bytes,utilization_before,utilization_after,moves,bytes_moved,threshold_utilization,spread_before_bytes,spread_after_bytes,utilization_spread_before,utilization_spread_after
+move,n2,n1,o2,500000000000,500000000000,,,,,,,,,,,,,,
+node,n1,,,,,,,8000000000000,1250000000000,750000000000,0.84375,0.90625,,,,,,,
+node,n2,,,,,,,8000000000000,0,500000000000,1,0.9375,,,,,,,
+summary,,,,,,,,,,,,,1,500000000000,0.137438953472,1250000000000,250000000000,0.15625,0.03125
+`, out.String())
+}
+
+func TestJsonDecode_FormatCSVPlacement(t *testing.T) {
+	input := `[{"name":"n1","zone":"a","rack":"r1","capacity_bytes":10},{"name":"n2","rack":"r2","capacity_bytes":10}]`
+	var out bytes.Buffer
+	assert.Nil(t, JsonDecode(bytes.NewBufferString(input), &out, &Options{Format: CSV}))
+	assert.Contains(t, out.String(), "\nnode,n1,,,,,a,r1,10,")
+	assert.Contains(t, out.String(), "\nnode,n2,,,,,,r2,10,")
+}
+
+func TestJsonDecode_FormatEmpty(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, JsonDecode(bytes.NewBuffer([]byte("[]")), &out, &Options{Format: JSONL}))
+	assert.Equal(t, `{"type":"summary","moves":0,"bytes_moved":0,"threshold_utilization":0,"spread_before_bytes":0,"spread_after_bytes":0,"utilization_spread_before":0,"utilization_spread_after":0,"nodes":[]}
+`, out.String())
 }
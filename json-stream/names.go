@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// nameChecker finds objects that share a name.
+type nameChecker interface {
+	// add records the name of the object at offset, stored on node.
+	add(name string, node int, offset int64) error
+	// duplicates calls fn for every object added after another of the
+	// same name, with the offset of the first.
+	duplicates(fn func(d nameEntry, first int64)) error
+	Close() error
+}
+
+// nameEntry is an object name, where the object is in the input and the
+// index of its node.
+type nameEntry struct {
+	name   string
+	node   int
+	offset int64
+}
+
+func nameLess(a, b *nameEntry) bool {
+	if a.name != b.name {
+		return a.name < b.name
+	}
+	return a.offset < b.offset
+}
+
+// nameSet is a nameChecker that keeps every name in memory.
+type nameSet struct {
+	first map[string]int64 // offset of each name's first object
+	dups  []nameDup
+}
+
+type nameDup struct {
+	nameEntry
+	first int64
+}
+
+func newNameSet() *nameSet {
+	return &nameSet{first: map[string]int64{}}
+}
+
+func (s *nameSet) add(name string, node int, offset int64) error {
+	if first, ok := s.first[name]; ok {
+		s.dups = append(s.dups, nameDup{nameEntry{name, node, offset}, first})
+	} else {
+		s.first[name] = offset
+	}
+	return nil
+}
+
+func (s *nameSet) duplicates(fn func(d nameEntry, first int64)) error {
+	for _, d := range s.dups {
+		fn(d.nameEntry, d.first)
+	}
+	return nil
+}
+
+func (s *nameSet) Close() error { return nil }
+
+// nameIndex is a nameChecker for inventories with more names than fit
+// in memory. Like objectIndex it is an external sort: names are
+// buffered, and each time the buffer fills it is sorted by name and
+// spilled to a temporary file as a run. duplicates merges the runs, in
+// which objects of the same name end up next to each other.
+type nameIndex struct {
+	dir         string
+	maxInMemory int
+	buf         []nameEntry
+	runs        []*os.File
+}
+
+func newNameIndex(dir string, maxInMemory int) *nameIndex {
+	if maxInMemory < 1 {
+		maxInMemory = 1
+	}
+	return &nameIndex{dir: dir, maxInMemory: maxInMemory}
+}
+
+func (x *nameIndex) add(name string, node int, offset int64) error {
+	x.buf = append(x.buf, nameEntry{name, node, offset})
+	if len(x.buf) >= x.maxInMemory {
+		return x.spill()
+	}
+	return nil
+}
+
+func (x *nameIndex) sortBuf() {
+	sort.Slice(x.buf, func(i, j int) bool { return nameLess(&x.buf[i], &x.buf[j]) })
+}
+
+// spill writes the buffer to a new run and empties it. A record is the
+// length of the name, the name, the node and the offset, as varints.
+func (x *nameIndex) spill() error {
+	x.sortBuf()
+	f, err := ioutil.TempFile(x.dir, "json-stream-names-")
+	if err != nil {
+		return err
+	}
+	x.runs = append(x.runs, f)
+	w := bufio.NewWriter(f)
+	var rec []byte
+	for i := range x.buf {
+		e := &x.buf[i]
+		rec = binary.AppendUvarint(rec[:0], uint64(len(e.name)))
+		rec = append(rec, e.name...)
+		rec = binary.AppendUvarint(rec, uint64(e.node))
+		rec = binary.AppendVarint(rec, e.offset)
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	x.buf = x.buf[:0]
+	return w.Flush()
+}
+
+func (x *nameIndex) duplicates(fn func(d nameEntry, first int64)) error {
+	x.sortBuf()
+	h := &nameHeap{}
+	for _, f := range x.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := &nameRun{r: bufio.NewReader(f)}
+		if err := r.next(); err != nil {
+			return err
+		}
+		if r.ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	if len(x.buf) > 0 {
+		h.runs = append(h.runs, &nameRun{buf: x.buf[1:], cur: x.buf[0], ok: true})
+	}
+	heap.Init(h)
+
+	var prev nameEntry
+	first, havePrev := int64(0), false
+	for h.Len() > 0 {
+		r := h.runs[0]
+		e := r.cur
+		if havePrev && e.name == prev.name {
+			fn(e, first)
+		} else {
+			first = e.offset
+		}
+		prev, havePrev = e, true
+		if err := r.next(); err != nil {
+			return err
+		}
+		if r.ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// Close removes the spilled runs.
+func (x *nameIndex) Close() error {
+	var firstErr error
+	for _, f := range x.runs {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := os.Remove(f.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	x.runs = nil
+	return firstErr
+}
+
+// nameRun reads the names of a run, spilled or in memory, in order.
+type nameRun struct {
+	r   *bufio.Reader // nil for a run in memory
+	buf []nameEntry   // the rest of a run in memory
+	cur nameEntry
+	ok  bool // cur is valid
+}
+
+// next reads the next name into cur, clearing ok at the end of the run.
+func (r *nameRun) next() error {
+	if r.r == nil {
+		r.ok = len(r.buf) > 0
+		if r.ok {
+			r.cur, r.buf = r.buf[0], r.buf[1:]
+		}
+		return nil
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		r.ok = false
+		return nil
+	} else if err != nil {
+		return err
+	}
+	name := make([]byte, n)
+	if _, err := io.ReadFull(r.r, name); err != nil {
+		return err
+	}
+	node, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	offset, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return err
+	}
+	r.cur, r.ok = nameEntry{string(name), int(node), offset}, true
+	return nil
+}
+
+// nameHeap orders runs by their current name.
+type nameHeap struct {
+	runs []*nameRun
+}
+
+func (h *nameHeap) Len() int           { return len(h.runs) }
+func (h *nameHeap) Less(i, j int) bool { return nameLess(&h.runs[i].cur, &h.runs[j].cur) }
+func (h *nameHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *nameHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*nameRun)) }
+func (h *nameHeap) Pop() interface{} {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nameDupResult struct {
+	name   string
+	node   int
+	offset int64
+	first  int64
+}
+
+// duplicatesOf adds names to c, the object at offset i on node i%3, and
+// returns the duplicates it finds, ordered by offset.
+func duplicatesOf(t *testing.T, c nameChecker, names []string) []nameDupResult {
+	t.Helper()
+	for i, name := range names {
+		assert.Nil(t, c.add(name, i%3, int64(i)))
+	}
+	var got []nameDupResult
+	assert.Nil(t, c.duplicates(func(d nameEntry, first int64) {
+		got = append(got, nameDupResult{d.name, d.node, d.offset, first})
+	}))
+	sort.Slice(got, func(i, j int) bool { return got[i].offset < got[j].offset })
+	return got
+}
+
+func TestNameIndex_SpilledRuns(t *testing.T) {
+	names := []string{"c", "a", "b", "a", "d", "c", "e", "a", "f", "b", "g"}
+	want := []nameDupResult{
+		{"a", 0, 3, 1},
+		{"c", 2, 5, 0},
+		{"a", 1, 7, 1},
+		{"b", 0, 9, 2},
+	}
+	assert.Equal(t, want, duplicatesOf(t, newNameSet(), names))
+
+	dir := t.TempDir()
+	x := newNameIndex(dir, 2)
+	got := duplicatesOf(t, x, names)
+	// Five runs of two names are spilled; the last name stays in memory.
+	assert.Len(t, x.runs, 5)
+	assert.Len(t, x.buf, 1)
+	assert.Equal(t, want, got)
+
+	assert.Nil(t, x.Close())
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestNameIndex_NoDuplicates(t *testing.T) {
+	x := newNameIndex(t.TempDir(), 1)
+	defer x.Close()
+	assert.Empty(t, duplicatesOf(t, x, []string{"b", "a", "c"}))
+	assert.Len(t, x.runs, 3)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Options configure a rebalance plan.
+type Options struct {
+	// Threshold is the utilization spread at which nodes are
+	// considered balanced. The zero value is DefaultThreshold.
+	Threshold Threshold
+	Strategy  Strategy
+	Format    Format // of the plan written
+
+	// SpillDir and MaxInMemory configure the indexes of JsonStream:
+	// at most MaxInMemory objects are kept in memory by each, and
+	// the rest are spilled to temporary files in SpillDir, or the
+	// default temporary directory if SpillDir is empty.
+	SpillDir    string
+	MaxInMemory int
+}
+
+// DefaultMaxInMemory is the MaxInMemory used if it is not positive.
+const DefaultMaxInMemory = 1000000
+
+// DefaultThreshold is the Threshold used if none is given.
+var DefaultThreshold = Threshold{Bytes: humanize.TiByte}
+
+func (o *Options) withDefaults() Options {
+	opts := Options{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Threshold == (Threshold{}) {
+		opts.Threshold = DefaultThreshold
+	}
+	if opts.MaxInMemory < 1 {
+		opts.MaxInMemory = DefaultMaxInMemory
+	}
+	return opts
+}
+
+// Threshold is a utilization spread: the difference between the most
+// and the least utilized node, as a fraction of their capacities. It is
+// given either as a percentage, or in bytes of the mean node capacity.
+// For nodes of equal capacity, both are the spread of their free space.
+type Threshold struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseThreshold parses a threshold such as "1TiB", "500 GB",
+// "1099511627776" or "5%".
+func ParseThreshold(s string) (Threshold, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return Threshold{}, fmt.Errorf("Invalid threshold '%s', expected a percentage in (0, 100]", s)
+		}
+		return Threshold{Percent: p}, nil
+	}
+	b, err := humanize.ParseBytes(s)
+	if err != nil || b == 0 || b > 1<<63-1 {
+		return Threshold{}, fmt.Errorf("Invalid threshold '%s', expected a positive size in bytes or a percentage", s)
+	}
+	return Threshold{Bytes: int64(b)}, nil
+}
+
+func (t Threshold) String() string {
+	if t.Percent > 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return humanize.IBytes(uint64(t.Bytes))
+}
+
+// utilization returns the threshold as a fraction of capacity for the
+// nodes ids, those taking part in rebalancing.
+func (t Threshold) utilization(nodes []nodeState, ids []int) float64 {
+	if t.Percent > 0 {
+		return t.Percent / 100
+	}
+	total := float64(0)
+	for _, i := range ids {
+		total += float64(nodes[i].capacityInBytes)
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(t.Bytes) / (total / float64(len(ids)))
+}
+
+// Strategy selects the object moved from the most to the least
+// utilized node. The even split is the size that would leave the two
+// equally utilized; for nodes of equal capacity, half the gap between
+// their free space. Only non-empty objects smaller than twice the even
+// split are moved, since others would not narrow the gap.
+type Strategy int
+
+const (
+	// Smallest moves the smallest object, to move the fewest bytes
+	// at each step.
+	Smallest Strategy = iota
+	// LargestFit moves the largest object no larger than the even
+	// split, which never leaves the destination the more utilized of
+	// the two, or the smallest object if none is. It tends to take the
+	// fewest moves.
+	LargestFit
+	// BestFit moves the object closest to the even split, leaving the
+	// two nodes as close to each other as possible.
+	BestFit
+)
+
+var strategyNames = []struct {
+	name     string
+	strategy Strategy
+}{
+	{"smallest", Smallest},
+	{"largest-fit", LargestFit},
+	{"best-fit", BestFit},
+	{"min-bytes", Smallest},
+	{"min-moves", LargestFit},
+}
+
+// ParseStrategy returns the strategy named s: smallest (or min-bytes),
+// largest-fit (or min-moves) or best-fit.
+func ParseStrategy(s string) (Strategy, error) {
+	for _, n := range strategyNames {
+		if n.name == s {
+			return n.strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid strategy '%s'", s)
+}
+
+func (s Strategy) String() string {
+	for _, n := range strategyNames {
+		if n.strategy == s {
+			return n.name
+		}
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Format selects the encoding of a plan.
+type Format int
+
+const (
+	// Text is one line per move, "src dst object", followed by the
+	// final free space of each node.
+	Text Format = iota
+	// JSONL is one JSON object per line: a move record per move, then
+	// a summary record.
+	JSONL
+	// CSV is a header, a move row per move, a node row per node
+	// giving its zone, rack and free space, then a summary row with the
+	// totals.
+	CSV
+)
+
+func (f Format) String() string {
+	switch f {
+	case Text:
+		return "text"
+	case JSONL:
+		return "jsonl"
+	case CSV:
+		return "csv"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the Format named s, as returned by Format.String.
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{Text, JSONL, CSV} {
+		if f.String() == s {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid format '%s'", s)
+}
+
+// Move is a move of the plan.
+type Move struct {
+	Type            string `json:"type"` // "move"
+	Src             string `json:"src_node"`
+	Dst             string `json:"dst_node"`
+	Object          string `json:"object"`
+	SizeInBytes     int64  `json:"size_bytes"`
+	CumulativeBytes int64  `json:"cumulative_bytes"` // moved so far, including this move
+}
+
+// NodeSummary is the free space of a node before and after the plan.
+type NodeSummary struct {
+	Name              string  `json:"node"`
+	Zone              string  `json:"zone,omitempty"`
+	Rack              string  `json:"rack,omitempty"`
+	CapacityInBytes   int64   `json:"capacity_bytes"`
+	FreeBefore        int64   `json:"free_before_bytes"`
+	FreeAfter         int64   `json:"free_after_bytes"`
+	UtilizationBefore float64 `json:"utilization_before"`
+	UtilizationAfter  float64 `json:"utilization_after"`
+}
+
+// Summary ends a plan.
+type Summary struct {
+	Type       string `json:"type"` // "summary"
+	Moves      int64  `json:"moves"`
+	BytesMoved int64  `json:"bytes_moved"`
+	// Threshold is the utilization spread the plan aims for.
+	Threshold               float64       `json:"threshold_utilization"`
+	SpreadBefore            int64         `json:"spread_before_bytes"` // most minus least free space
+	SpreadAfter             int64         `json:"spread_after_bytes"`
+	UtilizationSpreadBefore float64       `json:"utilization_spread_before"` // most minus least utilization
+	UtilizationSpreadAfter  float64       `json:"utilization_spread_after"`
+	Nodes                   []NodeSummary `json:"nodes"`
+}
+
+// planWriter encodes a plan in one of the formats.
+type planWriter interface {
+	move(m *Move) error
+	// summary writes s and flushes any buffered data.
+	summary(s *Summary) error
+}
+
+func newPlanWriter(w io.Writer, f Format) (planWriter, error) {
+	switch f {
+	case Text:
+		return &textPlanWriter{w: w}, nil
+	case JSONL:
+		return &jsonlPlanWriter{enc: json.NewEncoder(w)}, nil
+	case CSV:
+		return newCSVPlanWriter(w), nil
+	}
+	return nil, fmt.Errorf("Invalid format '%v'", f)
+}
+
+type textPlanWriter struct {
+	w io.Writer
+}
+
+func (p *textPlanWriter) move(m *Move) error {
+	_, err := fmt.Fprintf(p.w, "%s %s %s\n", m.Src, m.Dst, m.Object)
+	return err
+}
+
+func (p *textPlanWriter) summary(s *Summary) error {
+	if _, err := fmt.Fprintf(p.w, "Final free space diff: %s\n", humanize.Comma(s.SpreadAfter)); err != nil {
+		return err
+	}
+	for i := range s.Nodes {
+		n := &s.Nodes[i]
+		if _, err := fmt.Fprintf(p.w, "Final free space: %s %s\n", n.Name, humanize.Comma(n.FreeAfter)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonlPlanWriter struct {
+	enc *json.Encoder
+}
+
+func (p *jsonlPlanWriter) move(m *Move) error       { return p.enc.Encode(m) }
+func (p *jsonlPlanWriter) summary(s *Summary) error { return p.enc.Encode(s) }
+
+var csvHeader = []string{
+	"type", "src_node", "dst_node", "object", "size_bytes", "cumulative_bytes",
+	"zone", "rack", "capacity_bytes", "free_before_bytes", "free_after_bytes",
+	"utilization_before", "utilization_after",
+	"moves", "bytes_moved", "threshold_utilization",
+	"spread_before_bytes", "spread_after_bytes",
+	"utilization_spread_before", "utilization_spread_after",
+}
+
+// csvPlanWriter writes moves, node summaries and the plan summary as
+// rows of one table. A node row names its node in the src_node column.
+// Columns that do not apply to a row are left empty.
+type csvPlanWriter struct {
+	w         *csv.Writer
+	wroteHead bool
+}
+
+func newCSVPlanWriter(w io.Writer) *csvPlanWriter {
+	return &csvPlanWriter{w: csv.NewWriter(w)}
+}
+
+// header writes the header unless it has been written already.
+func (p *csvPlanWriter) header() error {
+	if p.wroteHead {
+		return nil
+	}
+	p.wroteHead = true
+	return p.w.Write(csvHeader)
+}
+
+// write writes row, padded with empty columns to the width of the
+// header.
+func (p *csvPlanWriter) write(row ...string) error {
+	if err := p.header(); err != nil {
+		return err
+	}
+	for len(row) < len(csvHeader) {
+		row = append(row, "")
+	}
+	return p.w.Write(row)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (p *csvPlanWriter) move(m *Move) error {
+	return p.write(
+		"move", m.Src, m.Dst, m.Object,
+		strconv.FormatInt(m.SizeInBytes, 10),
+		strconv.FormatInt(m.CumulativeBytes, 10),
+	)
+}
+
+func (p *csvPlanWriter) summary(s *Summary) error {
+	for i := range s.Nodes {
+		n := &s.Nodes[i]
+		err := p.write(
+			"node", n.Name, "", "", "", "",
+			n.Zone, n.Rack,
+			strconv.FormatInt(n.CapacityInBytes, 10),
+			strconv.FormatInt(n.FreeBefore, 10),
+			strconv.FormatInt(n.FreeAfter, 10),
+			formatFloat(n.UtilizationBefore),
+			formatFloat(n.UtilizationAfter),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	err := p.write(
+		"summary", "", "", "", "", "", "", "", "", "", "", "", "",
+		strconv.FormatInt(s.Moves, 10),
+		strconv.FormatInt(s.BytesMoved, 10),
+		formatFloat(s.Threshold),
+		strconv.FormatInt(s.SpreadBefore, 10),
+		strconv.FormatInt(s.SpreadAfter, 10),
+		formatFloat(s.UtilizationSpreadBefore),
+		formatFloat(s.UtilizationSpreadAfter),
+	)
+	if err != nil {
+		return err
+	}
+	p.w.Flush()
+	return p.w.Error()
+}
@@ -0,0 +1,80 @@
+package main
+
+// placement tracks the replicas of each replica group on each node and
+// in each failure domain, so that moves never put two replicas of a
+// group on one node, or in one domain. Only objects with a replica
+// group are tracked.
+type placement struct {
+	nodeGroups map[int]map[string]int    // replicas by node index and group, while decoding
+	nodes      map[string]map[string]int // replicas by node name and group
+	domains    map[string]map[string]int // replicas by domain and group
+}
+
+func newPlacement() *placement {
+	return &placement{nodeGroups: map[int]map[string]int{}}
+}
+
+// add records o, stored on node.
+func (p *placement) add(node int, o *object) {
+	if o.ReplicaGroup == "" {
+		return
+	}
+	groups := p.nodeGroups[node]
+	if groups == nil {
+		groups = map[string]int{}
+		p.nodeGroups[node] = groups
+	}
+	groups[o.ReplicaGroup]++
+}
+
+// finish sums the replicas of nodes by node name and by domain, once
+// every object has been added.
+func (p *placement) finish(nodes []nodeState) {
+	p.nodes = map[string]map[string]int{}
+	p.domains = map[string]map[string]int{}
+	for node, groups := range p.nodeGroups {
+		for group, n := range groups {
+			change(p.nodes, nodes[node].name, group, n)
+			change(p.domains, nodes[node].domain, group, n)
+		}
+	}
+	p.nodeGroups = nil
+}
+
+// change adds n to the replicas of group counted under key in counts.
+func change(counts map[string]map[string]int, key, group string, n int) {
+	groups := counts[key]
+	if groups == nil {
+		groups = map[string]int{}
+		counts[key] = groups
+	}
+	groups[group] += n
+	if groups[group] == 0 {
+		delete(groups, group)
+	}
+}
+
+// allows reports whether o may move from src to dst. A replica never
+// joins another of its group on dst, even within one domain.
+func (p *placement) allows(o *object, src, dst *nodeState) bool {
+	if o.ReplicaGroup == "" {
+		return true
+	}
+	if p.nodes[dst.name][o.ReplicaGroup] > 0 {
+		return false
+	}
+	return src.domain == dst.domain || p.domains[dst.domain][o.ReplicaGroup] == 0
+}
+
+// moved records the move of o from src to dst.
+func (p *placement) moved(o *object, src, dst *nodeState) {
+	if o.ReplicaGroup == "" {
+		return
+	}
+	change(p.nodes, src.name, o.ReplicaGroup, -1)
+	change(p.nodes, dst.name, o.ReplicaGroup, 1)
+	if src.domain != dst.domain {
+		change(p.domains, src.domain, o.ReplicaGroup, -1)
+		change(p.domains, dst.domain, o.ReplicaGroup, 1)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"container/heap"
+	"io"
+	"math"
+)
+
+// nodeState is what the rebalancer keeps of a node: its free space,
+// placement and the objects that may be moved off it.
+type nodeState struct {
+	name                    string
+	zone, rack              string
+	domain                  string // failure domain: the zone, else the rack, else the node
+	capacityInBytes         int64
+	maxUsedInBytes          int64 // that moves may fill
+	initialFreeSpaceInBytes int64
+	freeSpaceInBytes        int64
+	objects                 *nodeObjects
+}
+
+func newNodeState(n *node, usedInBytes int64) nodeState {
+	ns := nodeState{
+		name:             n.Name,
+		zone:             n.Zone,
+		rack:             n.Rack,
+		capacityInBytes:  n.CapacityInBytes,
+		maxUsedInBytes:   n.CapacityInBytes,
+		freeSpaceInBytes: n.CapacityInBytes - usedInBytes,
+	}
+	if n.MaxUtilization > 0 && n.MaxUtilization < 1 {
+		ns.maxUsedInBytes = int64(float64(n.CapacityInBytes) * n.MaxUtilization)
+	}
+	switch {
+	case n.Zone != "":
+		ns.domain = "zone/" + n.Zone
+	case n.Rack != "":
+		ns.domain = "rack/" + n.Rack
+	default:
+		ns.domain = "node/" + n.Name
+	}
+	return ns
+}
+
+func (n *nodeState) usedInBytes() int64 {
+	return n.capacityInBytes - n.freeSpaceInBytes
+}
+
+// utilization returns the fraction of the capacity of n in use. Nodes
+// without capacity are taken to be full.
+func (n *nodeState) utilization() float64 {
+	if n.capacityInBytes <= 0 {
+		return 1
+	}
+	return float64(n.usedInBytes()) / float64(n.capacityInBytes)
+}
+
+// maxTries is the number of the most and the least utilized nodes
+// tried as the source and destination of a move when the most utilized
+// node has no object that may go to the least utilized one.
+const maxTries = 8
+
+// rebalance moves objects from the most to the least utilized node
+// until their utilization is within the threshold of opts, writing the
+// plan to writer in the format of opts. The object moved is chosen by
+// the strategy of opts, among those that narrow the gap between the
+// two, keep the destination within its maximum utilization and keep
+// the replicas of a group in distinct failure domains. Pinned objects
+// are not among the candidates of a node, so are never moved. If no
+// object fits, the next most and least utilized nodes are tried, up to
+// maxTries of each; rebalance stops when none of them can exchange
+// objects either.
+//
+// The nodes are kept in a max-heap and a min-heap by utilization, so
+// each move takes O(log nodes) plus a binary search of the objects of
+// the source node. Nodes without capacity take no part.
+func rebalance(nodes []nodeState, replicas *placement, writer io.Writer, opts Options) error {
+	plan, err := newPlanWriter(writer, opts.Format)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for i := range nodes {
+		nodes[i].initialFreeSpaceInBytes = nodes[i].freeSpaceInBytes
+		if nodes[i].capacityInBytes > 0 {
+			ids = append(ids, i)
+		}
+	}
+	summary := &Summary{
+		Type:      "summary",
+		Threshold: opts.Threshold.utilization(nodes, ids),
+		Nodes:     make([]NodeSummary, len(nodes)),
+	}
+	mostUsed := newUtilHeap(nodes, ids, true)
+	leastUsed := newUtilHeap(nodes, ids, false)
+	summary.SpreadBefore, summary.UtilizationSpreadBefore = spreads(nodes, ids)
+
+	for len(ids) > 1 {
+		spread := nodes[mostUsed.top()].utilization() - nodes[leastUsed.top()].utilization()
+		if spread <= summary.Threshold {
+			break
+		}
+
+		src, dst, c, ok, err := chooseMove(nodes, []int{mostUsed.top()}, []int{leastUsed.top()}, replicas, opts.Strategy)
+		if err == nil && !ok {
+			// The extremes cannot exchange objects; try their
+			// neighbours.
+			src, dst, c, ok, err = chooseMove(nodes, mostUsed.firstN(maxTries), leastUsed.firstN(maxTries), replicas, opts.Strategy)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		nodes[src].objects.remove(c)
+		nodes[dst].objects.add(c.object)
+		nodes[src].freeSpaceInBytes += c.SizeInBytes
+		nodes[dst].freeSpaceInBytes -= c.SizeInBytes
+		replicas.moved(&c.object, &nodes[src], &nodes[dst])
+		mostUsed.fix(src)
+		mostUsed.fix(dst)
+		leastUsed.fix(src)
+		leastUsed.fix(dst)
+		summary.Moves++
+		summary.BytesMoved += c.SizeInBytes
+		err = plan.move(&Move{
+			Type:            "move",
+			Src:             nodes[src].name,
+			Dst:             nodes[dst].name,
+			Object:          c.Name,
+			SizeInBytes:     c.SizeInBytes,
+			CumulativeBytes: summary.BytesMoved,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	summary.SpreadAfter, summary.UtilizationSpreadAfter = spreads(nodes, ids)
+	for i := range nodes {
+		n := &nodes[i]
+		summary.Nodes[i] = NodeSummary{
+			Name:              n.name,
+			Zone:              n.zone,
+			Rack:              n.rack,
+			CapacityInBytes:   n.capacityInBytes,
+			FreeBefore:        n.initialFreeSpaceInBytes,
+			FreeAfter:         n.freeSpaceInBytes,
+			UtilizationBefore: utilization(n.capacityInBytes-n.initialFreeSpaceInBytes, n.capacityInBytes),
+			UtilizationAfter:  n.utilization(),
+		}
+	}
+	return plan.summary(summary)
+}
+
+// chooseMove returns the first of srcs and dsts, tried in order, that
+// can exchange an object, and the object.
+func chooseMove(nodes []nodeState, srcs, dsts []int, replicas *placement, strategy Strategy) (int, int, candidate, bool, error) {
+	for _, src := range srcs {
+		for _, dst := range dsts {
+			if nodes[src].utilization() <= nodes[dst].utilization() {
+				// dsts are ordered by utilization; so are the rest.
+				break
+			}
+			c, ok := chooseObject(&nodes[src], &nodes[dst], replicas, strategy)
+			if err := nodes[src].objects.err(); err != nil {
+				return -1, -1, candidate{}, false, err
+			}
+			if ok {
+				return src, dst, c, true, nil
+			}
+		}
+	}
+	return -1, -1, candidate{}, false, nil
+}
+
+// chooseObject returns the object to move from src to dst, if any.
+func chooseObject(src, dst *nodeState, replicas *placement, strategy Strategy) (candidate, bool) {
+	// Moving s bytes changes the utilization of src and dst by s/cap
+	// each. The even split leaves them equal; anything smaller than
+	// twice that narrows the gap, and lowers the sum of used²/cap over
+	// all nodes, so rebalancing always ends.
+	inverse := 1/float64(src.capacityInBytes) + 1/float64(dst.capacityInBytes)
+	even := (src.utilization() - dst.utilization()) / inverse
+	f := fit{
+		min: 1,
+		max: int64(math.Ceil(2*even)) - 1,
+		ok:  func(o *object) bool { return replicas.allows(o, src, dst) },
+	}
+	if room := dst.maxUsedInBytes - dst.usedInBytes(); room < f.max {
+		f.max = room
+	}
+	if f.max < f.min {
+		return candidate{}, false
+	}
+
+	objects := src.objects
+	switch strategy {
+	case LargestFit:
+		under := f
+		if e := int64(even); e < under.max {
+			under.max = e
+		}
+		if c, ok := objects.atMost(under); ok {
+			return c, true
+		}
+		// Nothing fits; settle for narrowing the gap.
+		return objects.atLeast(f)
+	case BestFit:
+		return objects.nearest(int64(math.Round(even)), f)
+	}
+	return objects.atLeast(f)
+}
+
+func utilization(usedInBytes, capacityInBytes int64) float64 {
+	if capacityInBytes <= 0 {
+		return 1
+	}
+	return float64(usedInBytes) / float64(capacityInBytes)
+}
+
+// spreads returns the spread of free space of all nodes, and of the
+// utilization of the nodes ids.
+func spreads(nodes []nodeState, ids []int) (int64, float64) {
+	var free int64
+	if len(nodes) > 0 {
+		min, max := nodes[0].freeSpaceInBytes, nodes[0].freeSpaceInBytes
+		for i := range nodes {
+			if f := nodes[i].freeSpaceInBytes; f < min {
+				min = f
+			} else if f > max {
+				max = f
+			}
+		}
+		free = max - min
+	}
+	var util float64
+	if len(ids) > 0 {
+		min, max := nodes[ids[0]].utilization(), nodes[ids[0]].utilization()
+		for _, i := range ids {
+			if u := nodes[i].utilization(); u < min {
+				min = u
+			} else if u > max {
+				max = u
+			}
+		}
+		util = max - min
+	}
+	return free, util
+}
+
+// utilHeap orders the indices of nodes by utilization, most or least
+// utilized first. It tracks the position of each node so a node can be
+// fixed after its utilization changes.
+type utilHeap struct {
+	nodes     []nodeState
+	ids       []int // heap of node indices
+	pos       []int // pos[i] is the position of node i in ids
+	mostFirst bool
+}
+
+func newUtilHeap(nodes []nodeState, ids []int, mostFirst bool) *utilHeap {
+	h := &utilHeap{
+		nodes:     nodes,
+		ids:       append([]int(nil), ids...),
+		pos:       make([]int, len(nodes)),
+		mostFirst: mostFirst,
+	}
+	for p, i := range h.ids {
+		h.pos[i] = p
+	}
+	heap.Init(h)
+	return h
+}
+
+func (h *utilHeap) top() int     { return h.ids[0] }
+func (h *utilHeap) fix(node int) { heap.Fix(h, h.pos[node]) }
+
+// firstN returns up to n nodes from the top, in order.
+func (h *utilHeap) firstN(n int) []int {
+	var first []int
+	for len(first) < n && h.Len() > 0 {
+		first = append(first, heap.Pop(h).(int))
+	}
+	for _, i := range first {
+		heap.Push(h, i)
+	}
+	return first
+}
+
+func (h *utilHeap) Len() int { return len(h.ids) }
+func (h *utilHeap) Less(i, j int) bool {
+	a, b := h.nodes[h.ids[i]].utilization(), h.nodes[h.ids[j]].utilization()
+	if a != b {
+		return (a > b) == h.mostFirst
+	}
+	return h.ids[i] < h.ids[j]
+}
+func (h *utilHeap) Swap(i, j int) {
+	h.ids[i], h.ids[j] = h.ids[j], h.ids[i]
+	h.pos[h.ids[i]], h.pos[h.ids[j]] = i, j
+}
+func (h *utilHeap) Push(x interface{}) {
+	h.pos[x.(int)] = len(h.ids)
+	h.ids = append(h.ids, x.(int))
+}
+func (h *utilHeap) Pop() interface{} {
+	x := h.ids[len(h.ids)-1]
+	h.ids = h.ids[:len(h.ids)-1]
+	return x
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/assert"
+)
+
+const strategyInventory = `[
+	{"name": "n1", "capacity_bytes": 16, "objects": [
+		{"name": "o1", "size_bytes": 1},
+		{"name": "o2", "size_bytes": 2},
+		{"name": "o5", "size_bytes": 5}
+	]},
+	{"name": "n2", "capacity_bytes": 16, "objects": []}
+]`
+
+// planBoth runs JsonDecode and JsonStream on input and checks that they
+// plan alike, returning the plan.
+func planBoth(t *testing.T, input string, opts *Options) string {
+	t.Helper()
+	streamOpts := *opts
+	streamOpts.SpillDir, streamOpts.MaxInMemory = t.TempDir(), 1
+	var decoded, streamed bytes.Buffer
+	assert.Nil(t, JsonDecode(strings.NewReader(input), &decoded, opts))
+	assert.Nil(t, JsonStream(strings.NewReader(input), &streamed, &streamOpts))
+	assert.Equal(t, decoded.String(), streamed.String())
+	return decoded.String()
+}
+
+func TestRebalance_Strategies(t *testing.T) {
+	tests := []struct {
+		threshold string
+		strategy  string
+		moves     string
+		diff      string
+	}{
+		{"1", "smallest", "n1 n2 o1\nn1 n2 o2\n", "2"},
+		{"1", "min-bytes", "n1 n2 o1\nn1 n2 o2\n", "2"},
+		{"1", "largest-fit", "n1 n2 o2\nn1 n2 o1\n", "2"},
+		{"1", "min-moves", "n1 n2 o2\nn1 n2 o1\n", "2"},
+		{"1", "best-fit", "n1 n2 o5\n", "2"},
+		{"7", "smallest", "n1 n2 o1\n", "6"},
+		{"40%", "smallest", "n1 n2 o1\n", "6"},
+		{"50%", "smallest", "", "8"},
+	}
+	for _, tt := range tests {
+		threshold, err := ParseThreshold(tt.threshold)
+		assert.Nil(t, err)
+		strategy, err := ParseStrategy(tt.strategy)
+		assert.Nil(t, err)
+
+		got := planBoth(t, strategyInventory, &Options{Threshold: threshold, Strategy: strategy})
+		want := tt.moves + "Final free space diff: " + tt.diff + "\n"
+		assert.True(t, strings.HasPrefix(got, want), "%s %s: got %q, want prefix %q", tt.threshold, tt.strategy, got, want)
+	}
+}
+
+func TestRebalance_Utilization(t *testing.T) {
+	// n2 has the most free space, but n1 the least utilization.
+	input := `[
+		{"name": "n1", "capacity_bytes": 100, "objects": [
+			{"name": "o1", "size_bytes": 10},
+			{"name": "o2", "size_bytes": 10},
+			{"name": "o3", "size_bytes": 10},
+			{"name": "o4", "size_bytes": 10},
+			{"name": "o5", "size_bytes": 10}
+		]},
+		{"name": "n2", "capacity_bytes": 1000, "objects": [
+			{"name": "p1", "size_bytes": 100}
+		]}
+	]`
+	got := planBoth(t, input, &Options{Threshold: Threshold{Percent: 10}, Format: JSONL})
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if !assert.Len(t, lines, 4) {
+		return
+	}
+	for _, line := range lines[:3] {
+		assert.Contains(t, line, `"src_node":"n1","dst_node":"n2"`)
+	}
+	var summary Summary
+	assert.Nil(t, json.Unmarshal([]byte(lines[3]), &summary))
+	assert.InDelta(t, 0.4, summary.UtilizationSpreadBefore, 1e-9)
+	assert.InDelta(t, 0.07, summary.UtilizationSpreadAfter, 1e-9)
+	assert.InDelta(t, 0.2, summary.Nodes[0].UtilizationAfter, 1e-9)
+	assert.InDelta(t, 0.13, summary.Nodes[1].UtilizationAfter, 1e-9)
+}
+
+func TestRebalance_Placement(t *testing.T) {
+	// n1 can only give r1, since p is pinned. r1 may not join its
+	// replica r2 in zone c on n3, the least utilized node, so it goes
+	// to n2 instead.
+	const input = `[
+		{"name": "n1", "zone": "a", "capacity_bytes": 100, "objects": [
+			{"name": "r1", "size_bytes": 10, "replica_group": "g"},
+			{"name": "p", "size_bytes": 30, "pinned": true}
+		]},
+		{"name": "n2", "zone": "b", "capacity_bytes": 100%s, "objects": [
+			{"name": "y", "size_bytes": 20}
+		]},
+		{"name": "n3", "zone": "c", "capacity_bytes": 100, "objects": [
+			{"name": "r2", "size_bytes": 10, "replica_group": "g"}
+		]}
+	]`
+	opts := &Options{Threshold: Threshold{Percent: 1}}
+
+	got := planBoth(t, fmt.Sprintf(input, ""), opts)
+	assert.True(t, strings.HasPrefix(got, "n1 n2 r1\nFinal"), got)
+
+	// Capped at 25%, n2 has no room for r1.
+	got = planBoth(t, fmt.Sprintf(input, `, "max_utilization": 0.25`), opts)
+	assert.True(t, strings.HasPrefix(got, "Final"), got)
+
+	// Without zones, each node is its own failure domain.
+	noZones := strings.NewReplacer(`"zone": "a", `, "", `"zone": "b", `, "", `"zone": "c", `, "").Replace(fmt.Sprintf(input, ""))
+	got = planBoth(t, noZones, opts)
+	assert.True(t, strings.HasPrefix(got, "n1 n2 r1\nFinal"), got)
+
+	// In one zone, r1 still may not join r2 on n3.
+	oneZone := strings.NewReplacer(`"zone": "a"`, `"zone": "z"`, `"zone": "b"`, `"zone": "z"`, `"zone": "c"`, `"zone": "z"`).Replace(fmt.Sprintf(input, ""))
+	got = planBoth(t, oneZone, opts)
+	assert.True(t, strings.HasPrefix(got, "n1 n2 r1\n"), got)
+	assert.NotContains(t, got, "n3 r1\n")
+}
+
+func TestParseThreshold(t *testing.T) {
+	for s, want := range map[string]Threshold{
+		"1099511627776": {Bytes: humanize.TiByte},
+		"1TiB":          {Bytes: humanize.TiByte},
+		"500 GB":        {Bytes: 500 * humanize.GByte},
+		"5%":            {Percent: 5},
+		"0.5 %":         {Percent: 0.5},
+	} {
+		got, err := ParseThreshold(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	for _, s := range []string{"", "0", "-1", "0%", "101%", "x%", "1 parsec"} {
+		_, err := ParseThreshold(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestThreshold_Utilization(t *testing.T) {
+	nodes := []nodeState{{capacityInBytes: 100}, {capacityInBytes: 0}, {capacityInBytes: 300}}
+	// The node without capacity takes no part, so the mean is 200.
+	assert.InDelta(t, 0.25, Threshold{Bytes: 50}.utilization(nodes, []int{0, 2}), 1e-9)
+	assert.InDelta(t, 0.05, Threshold{Percent: 5}.utilization(nodes, []int{0, 2}), 1e-9)
+	assert.Equal(t, 0.0, Threshold{Bytes: 50}.utilization(nodes, nil))
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, s := range []Strategy{Smallest, LargestFit, BestFit} {
+		got, err := ParseStrategy(s.String())
+		assert.Nil(t, err)
+		assert.Equal(t, s, got)
+	}
+	_, err := ParseStrategy("random")
+	assert.NotNil(t, err)
+}
+
+// inventory describes a synthetic inventory. Objects are spread over
+// the nodes unevenly, so that rebalancing has work to do.
+type inventory struct {
+	nodes, objects int
+}
+
+func (inv inventory) String() string {
+	return fmt.Sprintf("nodes=%d/objects=%d", inv.nodes, inv.objects)
+}
+
+// objectsOf returns the number of objects of node i. Node i holds a
+// share of all objects proportional to i+1.
+func (inv inventory) objectsOf(i int) int {
+	return inv.objectsBefore(i+1) - inv.objectsBefore(i)
+}
+
+// objectsBefore returns the number of objects of the first n nodes.
+func (inv inventory) objectsBefore(n int) int {
+	shares := int64(inv.nodes) * int64(inv.nodes+1) / 2
+	return int(int64(inv.objects) * (int64(n) * int64(n+1) / 2) / shares)
+}
+
+// capacity returns a node capacity of about twice what the fullest
+// node stores.
+func (inv inventory) capacity(meanSize int64) int64 {
+	return 4 * meanSize * int64(inv.objects/inv.nodes+1)
+}
+
+const benchMeanObjectSize = 64 * humanize.GiByte
+
+func benchObjectSize(rnd *rand.Rand) int64 {
+	return 1 + rnd.Int63n(2*benchMeanObjectSize)
+}
+
+// nodeStates returns the inventory as nodes held in memory.
+func (inv inventory) nodeStates(seed int64) []nodeState {
+	rnd := rand.New(rand.NewSource(seed))
+	capacity := inv.capacity(benchMeanObjectSize)
+	nodes := make([]nodeState, inv.nodes)
+	id := 0
+	for i := range nodes {
+		objects := make([]object, inv.objectsOf(i))
+		used := int64(0)
+		for j := range objects {
+			objects[j] = object{Name: "o" + strconv.Itoa(id), SizeInBytes: benchObjectSize(rnd)}
+			used += objects[j].SizeInBytes
+			id++
+		}
+		nodes[i] = newNodeState(&node{Name: "n" + strconv.Itoa(i), CapacityInBytes: capacity}, used)
+		nodes[i].objects = newNodeObjects(sortObjects(objects))
+	}
+	return nodes
+}
+
+// writeJSON writes the inventory as JSON to w.
+func (inv inventory) writeJSON(w io.Writer, seed int64) error {
+	rnd := rand.New(rand.NewSource(seed))
+	capacity := inv.capacity(benchMeanObjectSize)
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[")
+	id := 0
+	for i := 0; i < inv.nodes; i++ {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		fmt.Fprintf(bw, `{"name":"n%d","capacity_bytes":%d,"objects":[`, i, capacity)
+		for j, n := 0, inv.objectsOf(i); j < n; j++ {
+			if j > 0 {
+				bw.WriteString(",")
+			}
+			fmt.Fprintf(bw, `{"name":"o%d","size_bytes":%d}`, id, benchObjectSize(rnd))
+			id++
+		}
+		bw.WriteString("]}")
+	}
+	bw.WriteString("]")
+	return bw.Flush()
+}
+
+// benchInventories are the inventories benchmarked. The largest takes
+// about a GB of memory to hold; select smaller ones with -bench, e.g.
+// -bench 'Rebalance/smallest/nodes=10000/objects=1000000$'.
+var benchInventories = []inventory{
+	{nodes: 10000, objects: 100000},
+	{nodes: 10000, objects: 1000000},
+	{nodes: 10000, objects: 10000000},
+}
+
+func BenchmarkRebalance(b *testing.B) {
+	for _, strategy := range []Strategy{Smallest, LargestFit, BestFit} {
+		opts := (&Options{Strategy: strategy}).withDefaults()
+		for _, inv := range benchInventories {
+			b.Run(strategy.String()+"/"+inv.String(), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					nodes := inv.nodeStates(1)
+					replicas := newPlacement()
+					replicas.finish(nodes)
+					b.StartTimer()
+					if err := rebalance(nodes, replicas, ioutil.Discard, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkJsonStream measures decoding, indexing and rebalancing an
+// inventory generated on the fly, so the JSON is never held in memory.
+func BenchmarkJsonStream(b *testing.B) {
+	for _, inv := range benchInventories {
+		b.Run(inv.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pr, pw := io.Pipe()
+				go func() { pw.CloseWithError(inv.writeJSON(pw, 1)) }()
+				err := JsonStream(pr, ioutil.Discard, &Options{SpillDir: b.TempDir()})
+				pr.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+)
+
+// JsonStream plans the same moves as JsonDecode without holding the
+// inventory in memory. Nodes and their objects are decoded one at a
+// time; only the free space of each node, a size-ordered index of the
+// objects that may be moved, a name-ordered index of all objects to
+// find duplicate names and the placement of replicas are kept. Each
+// index keeps at most opts.MaxInMemory objects in memory and spills the
+// rest to temporary files in opts.SpillDir.
+func JsonStream(reader io.Reader, writer io.Writer, opts *Options) error {
+	o := opts.withDefaults()
+	index := newObjectIndex(o.SpillDir, o.MaxInMemory)
+	defer index.Close()
+	names := newNameIndex(o.SpillDir, o.MaxInMemory)
+	defer names.Close()
+
+	replicas := newPlacement()
+	nodes, err := newInventoryDecoder(reader, names).decode(func(i int, o object) error {
+		replicas.add(i, &o)
+		if o.Pinned {
+			return nil
+		}
+		return index.add(i, o)
+	})
+	if err != nil {
+		return err
+	}
+	replicas.finish(nodes)
+
+	if err := index.finish(); err != nil {
+		return err
+	}
+	for i := range nodes {
+		nodes[i].objects = index.objects(i)
+	}
+	return rebalance(nodes, replicas, writer, o)
+}